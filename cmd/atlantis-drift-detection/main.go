@@ -2,19 +2,31 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/cresta/atlantis-drift-detection/internal/daemon"
 	"github.com/cresta/atlantis-drift-detection/internal/drifter"
+	"github.com/cresta/atlantis-drift-detection/internal/filter"
+	"github.com/cresta/atlantis-drift-detection/internal/metrics"
 	"github.com/cresta/atlantis-drift-detection/internal/notification"
 	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
 	"github.com/cresta/atlantis-drift-detection/internal/terraform"
+	"github.com/cresta/atlantis-drift-detection/internal/tracing"
 	"github.com/cresta/gogit"
 	"github.com/cresta/gogithub"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
 
 	// Empty import allows pinning to version atlantis uses
 	_ "github.com/nlopes/slack"
@@ -23,19 +35,56 @@ import (
 import "github.com/joeshaw/envdecode"
 
 type config struct {
-	Repo               string        `env:"REPO,required"`
-	AtlantisHostname   string        `env:"ATLANTIS_HOST,required"`
-	AtlantisToken      string        `env:"ATLANTIS_TOKEN,required"`
-	DirectoryWhitelist []string      `env:"DIRECTORY_WHITELIST"`
-	SlackWebhookURL    string        `env:"SLACK_WEBHOOK_URL"`
-	SkipWorkspaceCheck bool          `env:"SKIP_WORKSPACE_CHECK"`
-	ParallelRuns       int           `env:"PARALLEL_RUNS"`
-	DynamodbTable      string        `env:"DYNAMODB_TABLE"`
-	CacheValidDuration time.Duration `env:"CACHE_VALID_DURATION,default=24h"`
-	WorkflowOwner      string        `env:"WORKFLOW_OWNER"`
-	WorkflowRepo       string        `env:"WORKFLOW_REPO"`
-	WorkflowId         string        `env:"WORKFLOW_ID"`
-	WorkflowRef        string        `env:"WORKFLOW_REF"`
+	Repo                string        `env:"REPO,required"`
+	AtlantisHostname    string        `env:"ATLANTIS_HOST,required"`
+	AtlantisToken       string        `env:"ATLANTIS_TOKEN,required"`
+	DirectoryWhitelist  []string      `env:"DIRECTORY_WHITELIST"`
+	DirectoryInclude    []string      `env:"DIRECTORY_INCLUDE"`
+	DirectoryExclude    []string      `env:"DIRECTORY_EXCLUDE"`
+	WorkspaceInclude    []string      `env:"WORKSPACE_INCLUDE"`
+	WorkspaceExclude    []string      `env:"WORKSPACE_EXCLUDE"`
+	ProjectInclude      []string      `env:"PROJECT_INCLUDE"`
+	ProjectExclude      []string      `env:"PROJECT_EXCLUDE"`
+	SlackWebhookURL     string        `env:"SLACK_WEBHOOK_URL"`
+	SkipWorkspaceCheck  bool          `env:"SKIP_WORKSPACE_CHECK"`
+	ParallelRuns        int           `env:"PARALLEL_RUNS"`
+	DynamodbTable       string        `env:"DYNAMODB_TABLE"`
+	CacheValidDuration  time.Duration `env:"CACHE_VALID_DURATION,default=24h"`
+	WorkflowOwner       string        `env:"WORKFLOW_OWNER"`
+	WorkflowRepo        string        `env:"WORKFLOW_REPO"`
+	WorkflowId          string        `env:"WORKFLOW_ID"`
+	WorkflowRef         string        `env:"WORKFLOW_REF"`
+	ReportFile          string        `env:"REPORT_FILE"`
+	ContinueOnError     bool          `env:"CONTINUE_ON_ERROR"`
+	ErrorBudget         int           `env:"ERROR_BUDGET"`
+	PlanMaxAttempts     int           `env:"PLAN_MAX_ATTEMPTS,default=3"`
+	PlanRetryBackoff    time.Duration `env:"PLAN_RETRY_BACKOFF,default=5s"`
+	PlanRetryMaxBackoff time.Duration `env:"PLAN_RETRY_MAX_BACKOFF,default=1m"`
+	Schedule            string        `env:"SCHEDULE,default=0 * * * *"`
+	ListenAddr          string        `env:"LISTEN_ADDR,default=:8080"`
+	RunOnStart          bool          `env:"RUN_ON_START,default=true"`
+	PushgatewayURL      string        `env:"PUSHGATEWAY_URL"`
+	DryRun              bool          `env:"DRY_RUN"`
+	ShardIndex          int           `env:"SHARD_INDEX"`
+	ShardCount          int           `env:"SHARD_COUNT"`
+	AtlantisRateLimit   string        `env:"ATLANTIS_RATE_LIMIT"`
+	AtlantisRateBurst   int           `env:"ATLANTIS_RATE_BURST,default=1"`
+	AtlantisSlowdown    int           `env:"ATLANTIS_SLOWDOWN_AFTER,default=3"`
+	WorkspaceTimeout    time.Duration `env:"WORKSPACE_TIMEOUT"`
+	RunDeadline         time.Duration `env:"RUN_DEADLINE"`
+	ShutdownGracePeriod time.Duration `env:"SHUTDOWN_GRACE_PERIOD,default=30s"`
+	CheckOrder          string        `env:"CHECK_ORDER,default=alphabetical"`
+	ParallelUnit        string        `env:"PARALLEL_UNIT,default=directory"`
+	ParallelPerDir      int           `env:"PARALLEL_RUNS_PER_DIRECTORY"`
+	IgnoredRemote       []string      `env:"IGNORED_REMOTE_WORKSPACES"`
+	CheckUnusedDefault  bool          `env:"CHECK_UNUSED_DEFAULT_WORKSPACE"`
+	DriftReminder       time.Duration `env:"DRIFT_REMINDER_INTERVAL"`
+	AcknowledgmentsFile string        `env:"ACKNOWLEDGMENTS_FILE,default=.drift-acknowledgments.yaml"`
+	StaleLockThreshold  time.Duration `env:"STALE_LOCK_THRESHOLD"`
+	FailureBackoff      time.Duration `env:"FAILURE_BACKOFF"`
+	FailureMaxBackoff   time.Duration `env:"FAILURE_MAX_BACKOFF,default=24h"`
+	FailureNotifyAfter  int           `env:"FAILURE_NOTIFY_AFTER"`
+	IgnoreFile          string        `env:"IGNORE_FILE,default=.drift-ignore.yaml"`
 }
 
 func loadEnvIfExists() error {
@@ -74,8 +123,95 @@ func (z *zapGogitLogger) Info(_ context.Context, msg string, strings map[string]
 
 var _ gogit.Logger = (*zapGogitLogger)(nil)
 
+func writeReport(filename string, report *drifter.Report) error {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+	if err := os.WriteFile(filename, b, 0644); err != nil {
+		return fmt.Errorf("failed to write report to %s: %w", filename, err)
+	}
+	return nil
+}
+
+func saveReport(logger *zap.Logger, filename string, report *drifter.Report) {
+	if filename == "" {
+		return
+	}
+	if err := writeReport(filename, report); err != nil {
+		logger.Error("failed to write report", zap.Error(err))
+		return
+	}
+	logger.Info("wrote drift report", zap.String("file", filename))
+}
+
+// pushMetrics sends the metrics of a one-shot run to a prometheus push gateway, since the process is gone before
+// anything could scrape it.  Each shard pushes to its own group so parallel jobs do not replace each other's metrics.
+func pushMetrics(logger *zap.Logger, cfg *config) {
+	if cfg.PushgatewayURL == "" {
+		return
+	}
+	err := push.New(cfg.PushgatewayURL, "atlantis_drift_detection").
+		Gatherer(prometheus.DefaultGatherer).
+		Grouping("shard", strconv.Itoa(cfg.ShardIndex)).
+		Push()
+	if err != nil {
+		logger.Error("failed to push metrics", zap.String("url", cfg.PushgatewayURL), zap.Error(err))
+		return
+	}
+	logger.Info("pushed metrics", zap.String("url", cfg.PushgatewayURL))
+}
+
+// serve runs drift checks on cfg.Schedule forever, exposing the status of the last run over HTTP
+func serve(ctx context.Context, logger *zap.Logger, cfg *config, d *drifter.Drifter) error {
+	schedule, err := daemon.ParseSchedule(cfg.Schedule)
+	if err != nil {
+		return fmt.Errorf("failed to parse schedule %s: %w", cfg.Schedule, err)
+	}
+	// Every run fetches into the same checkout, rather than cloning the repo again
+	d.KeepCheckout = true
+	defer d.RemoveCheckout()
+	dm := &daemon.Daemon{
+		Drift:      d.Drift,
+		Schedule:   schedule,
+		Logger:     logger.With(zap.String("daemon", "true")),
+		RunOnStart: cfg.RunOnStart,
+		OnReport: func(report *drifter.Report) {
+			saveReport(logger, cfg.ReportFile, report)
+		},
+	}
+	mux := dm.Handler()
+	mux.Handle("GET /metrics", promhttp.Handler())
+	srv := &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		logger.Info("serving status endpoints", zap.String("addr", cfg.ListenAddr))
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("status server failed", zap.Error(err))
+		}
+	}()
+	defer func() {
+		if err := srv.Close(); err != nil {
+			logger.Warn("failed to close status server", zap.Error(err))
+		}
+	}()
+	return dm.Run(ctx)
+}
+
 func main() {
-	ctx := context.Background()
+	// Set when a run is cut short by a signal, so that we exit non-zero only after deferred cleanup has run
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	serveMode := len(os.Args) > 1 && os.Args[1] == "serve"
 	zapCfg := zap.NewProductionConfig()
 	zapCfg.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
 	logger, err := zapCfg.Build(zap.AddCaller())
@@ -101,6 +237,18 @@ func main() {
 		logger.Info("setting up slack webhook notification")
 		notif.Notifications = append(notif.Notifications, slackClient)
 	}
+	if tracing.Enabled() {
+		logger.Info("setting up opentelemetry tracing")
+		shutdown, err := tracing.Setup(ctx)
+		if err != nil {
+			logger.Panic("failed to setup tracing", zap.Error(err))
+		}
+		defer func() {
+			if err := shutdown(context.Background()); err != nil {
+				logger.Warn("failed to flush traces", zap.Error(err))
+			}
+		}()
+	}
 	var existingConfig *gogithub.NewGQLClientConfig
 	if os.Getenv("GITHUB_TOKEN") != "" {
 		existingConfig = &gogithub.NewGQLClientConfig{Token: os.Getenv("GITHUB_TOKEN")}
@@ -118,6 +266,10 @@ func main() {
 	}
 
 	var cache processedcache.ProcessedCache = processedcache.Noop{}
+	if serveMode {
+		// A long-lived process can remember what it checked between runs, even without dynamodb
+		cache = &processedcache.Memory{}
+	}
 	if cfg.DynamodbTable != "" {
 		logger.Info("setting up dynamodb result cache")
 		cache, err = processedcache.NewDynamoDB(ctx, cfg.DynamodbTable)
@@ -126,25 +278,126 @@ func main() {
 		}
 	}
 
+	if tracing.Enabled() {
+		cache = &processedcache.Traced{Cache: cache}
+	}
+
+	directoryFilter, err := filter.New(cfg.DirectoryInclude, cfg.DirectoryExclude)
+	if err != nil {
+		logger.Panic("failed to parse directory filters", zap.Error(err))
+	}
+	workspaceFilter, err := filter.New(cfg.WorkspaceInclude, cfg.WorkspaceExclude)
+	if err != nil {
+		logger.Panic("failed to parse workspace filters", zap.Error(err))
+	}
+	projectFilter, err := filter.New(cfg.ProjectInclude, cfg.ProjectExclude)
+	if err != nil {
+		logger.Panic("failed to parse project filters", zap.Error(err))
+	}
+
+	ignoredRemoteWorkspaces, err := filter.NewLocationRules(cfg.IgnoredRemote)
+	if err != nil {
+		logger.Panic("failed to parse ignored remote workspaces", zap.Error(err))
+	}
+
+	order, err := drifter.ParseOrder(cfg.CheckOrder)
+	if err != nil {
+		logger.Panic("failed to parse check order", zap.Error(err))
+	}
+
+	parallelUnit, err := drifter.ParseParallelUnit(cfg.ParallelUnit)
+	if err != nil {
+		logger.Panic("failed to parse parallel unit", zap.Error(err))
+	}
+
+	var rateLimiter *atlantis.RateLimiter
+	if cfg.AtlantisRateLimit != "" {
+		limit, err := atlantis.ParseRate(cfg.AtlantisRateLimit)
+		if err != nil {
+			logger.Panic("failed to parse atlantis rate limit", zap.Error(err))
+		}
+		logger.Info("setting up atlantis rate limit", zap.Float64("limit-per-second", float64(limit)))
+		rateLimiter = atlantis.NewRateLimiter(limit, cfg.AtlantisRateBurst, cfg.AtlantisSlowdown)
+	}
+
 	d := drifter.Drifter{
-		DirectoryWhitelist: cfg.DirectoryWhitelist,
-		Logger:             logger.With(zap.String("drifter", "true")),
-		Repo:               cfg.Repo,
+		DirectoryWhitelist:          cfg.DirectoryWhitelist,
+		DirectoryFilter:             directoryFilter,
+		WorkspaceFilter:             workspaceFilter,
+		ProjectFilter:               projectFilter,
+		IgnoredRemoteWorkspaces:     ignoredRemoteWorkspaces,
+		CheckUnusedDefaultWorkspace: cfg.CheckUnusedDefault,
+		Logger:                      logger.With(zap.String("drifter", "true")),
+		Repo:                        cfg.Repo,
 		AtlantisClient: &atlantis.Client{
 			AtlantisHostname: cfg.AtlantisHostname,
 			Token:            cfg.AtlantisToken,
 			HTTPClient:       http.DefaultClient,
+			RateLimiter:      rateLimiter,
+			Logger:           logger.With(zap.String("atlantis", "true")),
+		},
+		ParallelRuns:            cfg.ParallelRuns,
+		ResultCache:             cache,
+		Cloner:                  cloner,
+		GithubClient:            ghClient,
+		CacheValidDuration:      cfg.CacheValidDuration,
+		Terraform:               &tf,
+		Notification:            notif,
+		SkipWorkspaceCheck:      cfg.SkipWorkspaceCheck,
+		ContinueOnError:         cfg.ContinueOnError,
+		ErrorBudget:             cfg.ErrorBudget,
+		PlanMaxAttempts:         cfg.PlanMaxAttempts,
+		PlanRetryBackoff:        cfg.PlanRetryBackoff,
+		PlanRetryMaxBackoff:     cfg.PlanRetryMaxBackoff,
+		Metrics:                 metrics.New(prometheus.DefaultRegisterer),
+		DryRun:                  cfg.DryRun,
+		WorkspaceTimeout:        cfg.WorkspaceTimeout,
+		RunDeadline:             cfg.RunDeadline,
+		ShutdownGracePeriod:     cfg.ShutdownGracePeriod,
+		Order:                   order,
+		ParallelUnit:            parallelUnit,
+		DriftReminderInterval:   cfg.DriftReminder,
+		AcknowledgmentsFile:     cfg.AcknowledgmentsFile,
+		StaleLockThreshold:      cfg.StaleLockThreshold,
+		FailureBackoff:          cfg.FailureBackoff,
+		FailureMaxBackoff:       cfg.FailureMaxBackoff,
+		FailureNotifyThreshold:  cfg.FailureNotifyAfter,
+		IgnoreFile:              cfg.IgnoreFile,
+		MaxParallelPerDirectory: cfg.ParallelPerDir,
+		Shard: drifter.Shard{
+			Index: cfg.ShardIndex,
+			Count: cfg.ShardCount,
 		},
-		ParallelRuns:       cfg.ParallelRuns,
-		ResultCache:        cache,
-		Cloner:             cloner,
-		GithubClient:       ghClient,
-		CacheValidDuration: cfg.CacheValidDuration,
-		Terraform:          &tf,
-		Notification:       notif,
-		SkipWorkspaceCheck: cfg.SkipWorkspaceCheck,
-	}
-	if err := d.Drift(ctx); err != nil {
-		logger.Panic("failed to drift", zap.Error(err))
+	}
+	if err := d.Shard.Validate(); err != nil {
+		logger.Panic("invalid shard config", zap.Error(err))
+	}
+	// A second signal kills the process without waiting for the grace period
+	context.AfterFunc(ctx, stop)
+	if serveMode {
+		if err := serve(ctx, logger, &cfg, &d); err != nil && !errors.Is(err, context.Canceled) {
+			logger.Panic("failed to serve", zap.Error(err))
+		}
+		logger.Info("shut down")
+		return
+	}
+	report, driftErr := d.Drift(ctx)
+	pushMetrics(logger, &cfg)
+	if report != nil {
+		saveReport(logger, cfg.ReportFile, report)
+		if cfg.DryRun {
+			logger.Info("dry run finished", zap.Int("would-plan", report.CountOutcome(drifter.OutcomeWouldPlan)))
+			if err := report.WriteSummary(os.Stdout); err != nil {
+				logger.Error("failed to write dry run summary", zap.Error(err))
+			}
+		}
+	}
+	if driftErr != nil {
+		if ctx.Err() != nil {
+			logger.Error("drift run interrupted", zap.Error(driftErr))
+			exitCode = 1
+			return
+		}
+		logger.Panic("failed to drift", zap.Error(driftErr))
 	}
 }
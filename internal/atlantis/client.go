@@ -7,16 +7,28 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/cresta/atlantis-drift-detection/internal/tracing"
 	"github.com/runatlantis/atlantis/server/controllers"
 	"github.com/runatlantis/atlantis/server/events/command"
+	"github.com/runatlantis/atlantis/server/events/models"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
 type Client struct {
 	AtlantisHostname string
 	Token            string
 	HTTPClient       *http.Client
+	// Optional: limits how quickly requests are sent to atlantis
+	RateLimiter *RateLimiter
+	// Optional: logs time spent waiting on the rate limiter
+	Logger *zap.Logger
 }
 
 type PlanSummaryRequest struct {
@@ -33,19 +45,105 @@ type PlanResult struct {
 
 type PlanSummary struct {
 	HasLock bool
+	// Only if HasLock: who holds the lock, as far as the plan failure tells us
+	Lock    *LockHolder
 	Summary string
+	// Only if not HasLock: the change counts terraform printed
+	Stats PlanStats
+	// Only if not HasLock: every resource the plan would change
+	Resources []ResourceChange
 }
 
-func (p *PlanResult) HasChanges() bool {
+// PlanStats are the changes terraform reported at the end of a plan
+type PlanStats struct {
+	Import  int
+	Add     int
+	Change  int
+	Destroy int
+	// True if the plan would change something to match the configuration
+	Changes bool
+	// True if terraform noticed objects that changed outside of Terraform since they were last applied
+	ChangesOutside bool
+}
+
+func newPlanStats(s models.PlanSuccessStats) PlanStats {
+	return PlanStats{
+		Import:         s.Import,
+		Add:            s.Add,
+		Change:         s.Change,
+		Destroy:        s.Destroy,
+		Changes:        s.Changes,
+		ChangesOutside: s.ChangesOutside,
+	}
+}
+
+// Resources returns the resources every project that was not locked would change
+func (p *PlanResult) Resources() []ResourceChange {
+	var ret []ResourceChange
+	for _, summary := range p.Summaries {
+		if !summary.HasLock {
+			ret = append(ret, summary.Resources...)
+		}
+	}
+	return ret
+}
+
+// Stats adds up the changes of every project that was not locked
+func (p *PlanResult) Stats() PlanStats {
+	var ret PlanStats
 	for _, summary := range p.Summaries {
 		if summary.HasLock {
 			continue
 		}
-		if !strings.Contains(summary.Summary, "No changes. ") {
-			return true
+		ret.Import += summary.Stats.Import
+		ret.Add += summary.Stats.Add
+		ret.Change += summary.Stats.Change
+		ret.Destroy += summary.Stats.Destroy
+		ret.Changes = ret.Changes || summary.Stats.Changes
+		ret.ChangesOutside = ret.ChangesOutside || summary.Stats.ChangesOutside
+	}
+	return ret
+}
+
+// LockHolder is the pull request holding an atlantis lock
+type LockHolder struct {
+	PullID  int
+	PullURL string
+	User    string
+	// When the lock was taken.  Zero if unknown.
+	Time time.Time
+}
+
+// Lock returns who holds the lock of the first locked project, or nil if no project is locked
+func (p *PlanResult) Lock() *LockHolder {
+	for _, summary := range p.Summaries {
+		if summary.HasLock {
+			if summary.Lock == nil {
+				return &LockHolder{}
+			}
+			return summary.Lock
 		}
 	}
-	return false
+	return nil
+}
+
+// Atlantis names the pull request by its URL, like https://github.com/org/repo/pull/45 or
+// https://gitlab.com/org/repo/-/merge_requests/45, or as #45
+var lockedByPull = regexp.MustCompile(`locked by an unapplied plan from pull (?:\S*/(?:pull|merge_requests)/|#)(\d+)\b`)
+
+// parseLockFailure finds the pull request holding the lock in an atlantis lock failure message
+func parseLockFailure(failure string) *LockHolder {
+	var ret LockHolder
+	if m := lockedByPull.FindStringSubmatch(failure); m != nil {
+		ret.PullID, _ = strconv.Atoi(m[1])
+	}
+	return &ret
+}
+
+// HasChanges returns true if the plan of any project that was not locked would change resources, going by the change
+// counts terraform printed rather than the wording of the summary
+func (p *PlanResult) HasChanges() bool {
+	return p.Stats().Changes
 }
 
 func (p *PlanResult) IsLocked() bool {
@@ -74,7 +172,17 @@ func (p *possiblyTemporaryError) Temporary() bool {
 	return true
 }
 
+const tracerName = "github.com/cresta/atlantis-drift-detection/internal/atlantis"
+
 func (c *Client) PlanSummary(ctx context.Context, req *PlanSummaryRequest) (*PlanResult, error) {
+	ctx, span := tracing.Tracer(tracerName).Start(ctx, "PlanSummary", trace.WithAttributes(tracing.DirKey.String(req.Dir), tracing.WorkspaceKey.String(req.Workspace)))
+	defer span.End()
+	ret, err := c.planSummary(ctx, req)
+	tracing.RecordError(span, err)
+	return ret, err
+}
+
+func (c *Client) planSummary(ctx context.Context, req *PlanSummaryRequest) (*PlanResult, error) {
 	planBody := controllers.APIRequest{
 		Repository: req.Repo,
 		Ref:        req.Ref,
@@ -101,10 +209,18 @@ func (c *Client) PlanSummary(ctx context.Context, req *PlanSummaryRequest) (*Pla
 	httpReq.Header.Set("X-Atlantis-Token", c.Token)
 	httpReq = httpReq.WithContext(ctx)
 
+	waited, err := c.RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error waiting for rate limiter: %w", err)
+	}
+	if waited > time.Millisecond && c.Logger != nil {
+		c.Logger.Info("Waited for atlantis rate limit", zap.String("dir", req.Dir), zap.String("workspace", req.Workspace), zap.Duration("waited", waited), zap.Float64("limit-per-second", float64(c.RateLimiter.Limit())))
+	}
 	resp, err := c.HTTPClient.Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("error making plan request to %s: %w", destination, err)
 	}
+	c.RateLimiter.observe(resp.StatusCode)
 	var fullBody bytes.Buffer
 	if _, err := io.Copy(&fullBody, resp.Body); err != nil {
 		return nil, fmt.Errorf("unable to read response body: %w", err)
@@ -144,13 +260,17 @@ func (c *Client) PlanSummary(ctx context.Context, req *PlanSummaryRequest) (*Pla
 	for _, result := range bodyResult.ProjectResults {
 		if result.Failure != "" {
 			if strings.Contains(result.Failure, "This project is currently locked ") {
-				ret.Summaries = append(ret.Summaries, PlanSummary{HasLock: true})
+				ret.Summaries = append(ret.Summaries, PlanSummary{HasLock: true, Lock: parseLockFailure(result.Failure)})
 				continue
 			}
 		}
 		if result.PlanSuccess != nil {
 			summary := result.PlanSuccess.Summary()
-			ret.Summaries = append(ret.Summaries, PlanSummary{Summary: summary})
+			ret.Summaries = append(ret.Summaries, PlanSummary{
+				Summary:   summary,
+				Stats:     newPlanStats(result.PlanSuccess.Stats()),
+				Resources: parseResourceChanges(result.PlanSuccess.TerraformOutput),
+			})
 			continue
 		}
 		return nil, fmt.Errorf("project result unknown failure: %s", result.Failure)
@@ -158,3 +278,56 @@ func (c *Client) PlanSummary(ctx context.Context, req *PlanSummaryRequest) (*Pla
 	}
 	return &ret, nil
 }
+
+// ListLocks returns every lock atlantis holds
+func (c *Client) ListLocks(ctx context.Context) ([]controllers.LockDetail, error) {
+	ctx, span := tracing.Tracer(tracerName).Start(ctx, "ListLocks")
+	defer span.End()
+	ret, err := c.listLocks(ctx)
+	tracing.RecordError(span, err)
+	return ret, err
+}
+
+func (c *Client) listLocks(ctx context.Context) ([]controllers.LockDetail, error) {
+	destination := fmt.Sprintf("%s/api/locks", c.AtlantisHostname)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, destination, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing destination: %w", err)
+	}
+	httpReq.Header.Set("X-Atlantis-Token", c.Token)
+	if _, err := c.RateLimiter.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("error waiting for rate limiter: %w", err)
+	}
+	resp, err := c.HTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error making locks request to %s: %w", destination, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	c.RateLimiter.observe(resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-200 response for %s: %d", destination, resp.StatusCode)
+	}
+	var result controllers.ListLocksResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error decoding locks response: %w", err)
+	}
+	return result.Locks, nil
+}
+
+// FindLock returns who holds the lock of dir/workspace in repo, or nil if atlantis has no such lock.  An unset
+// workspace matches a lock of the default workspace.
+func FindLock(locks []controllers.LockDetail, repo string, dir string, workspace string) *LockHolder {
+	for _, l := range locks {
+		if l.ProjectRepo == repo && filepath.Clean(l.ProjectRepoPath) == filepath.Clean(dir) && RemoteWorkspaceName(l.Workspace) == RemoteWorkspaceName(workspace) {
+			return &LockHolder{
+				PullID:  l.PullID,
+				PullURL: l.PullURL,
+				User:    l.User,
+				Time:    l.Time,
+			}
+		}
+	}
+	return nil
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/cresta/atlantis-drift-detection/internal/testhelper"
+	"github.com/cresta/atlantis-drift-detection/internal/tracing"
 	"github.com/stretchr/testify/require"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func makeTestClient(t *testing.T) *Client {
@@ -63,3 +66,116 @@ func TestClient_PlanSummaryChanges(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, ok.HasChanges())
 }
+
+func fakeAtlantis(t *testing.T, body string) *Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/plan", r.URL.Path)
+		require.Equal(t, "test-token", r.Header.Get("X-Atlantis-Token"))
+		_, err := w.Write([]byte(body))
+		require.NoError(t, err)
+	}))
+	t.Cleanup(srv.Close)
+	return &Client{
+		AtlantisHostname: srv.URL,
+		Token:            "test-token",
+		HTTPClient:       srv.Client(),
+	}
+}
+
+const noChangesResult = `{"ProjectResults":[{"PlanSuccess":{"TerraformOutput":"No changes. Your infrastructure matches the configuration."}}]}`
+
+func TestClient_PlanSummaryTraced(t *testing.T) {
+	recorder := testhelper.RecordSpans(t)
+	c := fakeAtlantis(t, noChangesResult)
+	ret, err := c.PlanSummary(context.Background(), &PlanSummaryRequest{Dir: "environments/aws/example", Workspace: "prod"})
+	require.NoError(t, err)
+	require.False(t, ret.HasChanges())
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, "PlanSummary", spans[0].Name())
+	require.Contains(t, spans[0].Attributes(), tracing.DirKey.String("environments/aws/example"))
+	require.Contains(t, spans[0].Attributes(), tracing.WorkspaceKey.String("prod"))
+}
+
+func TestClient_PlanSummaryLockHolder(t *testing.T) {
+	c := fakeAtlantis(t, `{"ProjectResults":[{"Failure":"This project is currently locked by an unapplied plan from pull #123. To continue, delete the lock from #123 or apply that plan and merge the pull request."}]}`)
+	ret, err := c.PlanSummary(context.Background(), &PlanSummaryRequest{Dir: "environments/aws/example", Workspace: "prod"})
+	require.NoError(t, err)
+	require.True(t, ret.IsLocked())
+	require.Equal(t, &LockHolder{PullID: 123}, ret.Lock())
+	require.Nil(t, (&PlanResult{}).Lock())
+}
+
+func TestParseLockFailure(t *testing.T) {
+	require.Equal(t, &LockHolder{PullID: 45}, parseLockFailure("This project is currently locked by an unapplied plan from pull https://github.com/org/infra-v2/pull/45. To continue, delete the lock from https://github.com/org/infra-v2/pull/45 or apply that plan and merge the pull request."))
+	require.Equal(t, &LockHolder{PullID: 7}, parseLockFailure("This project is currently locked by an unapplied plan from pull https://gitlab.com/team3/infra/-/merge_requests/7."))
+	require.Equal(t, &LockHolder{PullID: 123}, parseLockFailure("This project is currently locked by an unapplied plan from pull #123."))
+	require.Equal(t, &LockHolder{}, parseLockFailure("This project is currently locked by an unapplied plan from pull somewhere-v2."))
+}
+
+func TestClient_ListLocks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/locks", r.URL.Path)
+		require.Equal(t, "test-token", r.Header.Get("X-Atlantis-Token"))
+		_, err := w.Write([]byte(`{"Locks":[{"ProjectRepo":"cresta/terraform","ProjectRepoPath":"environments/aws/example","PullID":"123","PullURL":"https://github.com/cresta/terraform/pull/123","User":"someone","Workspace":"prod","Time":"2026-01-02T03:04:05Z"}]}`))
+		require.NoError(t, err)
+	}))
+	t.Cleanup(srv.Close)
+	c := &Client{
+		AtlantisHostname: srv.URL,
+		Token:            "test-token",
+		HTTPClient:       srv.Client(),
+	}
+	locks, err := c.ListLocks(context.Background())
+	require.NoError(t, err)
+	require.Len(t, locks, 1)
+	require.Equal(t, &LockHolder{
+		PullID:  123,
+		PullURL: "https://github.com/cresta/terraform/pull/123",
+		User:    "someone",
+		Time:    time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}, FindLock(locks, "cresta/terraform", "environments/aws/example/", "prod"))
+	require.Nil(t, FindLock(locks, "cresta/terraform", "environments/aws/example", "dev"))
+	require.Nil(t, FindLock(locks, "cresta/terraform", "environments/aws/example", ""))
+	locks[0].Workspace = "default"
+	require.NotNil(t, FindLock(locks, "cresta/terraform", "environments/aws/example", ""))
+	locks[0].Workspace = ""
+	require.NotNil(t, FindLock(locks, "cresta/terraform", "environments/aws/example", "default"))
+}
+
+func TestClient_PlanSummaryStats(t *testing.T) {
+	c := fakeAtlantis(t, `{"ProjectResults":[`+
+		`{"PlanSuccess":{"TerraformOutput":"Note: Objects have changed outside of Terraform\n\nPlan: 2 to add, 0 to change, 1 to destroy."}},`+
+		`{"PlanSuccess":{"TerraformOutput":"Plan: 1 to import, 0 to add, 3 to change, 0 to destroy."}},`+
+		`{"Failure":"This project is currently locked by an unapplied plan from pull #123."}]}`)
+	ret, err := c.PlanSummary(context.Background(), &PlanSummaryRequest{Dir: "environments/aws/example", Workspace: "prod"})
+	require.NoError(t, err)
+	require.True(t, ret.HasChanges())
+	require.Equal(t, PlanStats{Add: 2, Destroy: 1, Changes: true, ChangesOutside: true}, ret.Summaries[0].Stats)
+	require.Equal(t, PlanStats{Import: 1, Add: 2, Change: 3, Destroy: 1, Changes: true, ChangesOutside: true}, ret.Stats())
+
+	c = fakeAtlantis(t, noChangesResult)
+	ret, err = c.PlanSummary(context.Background(), &PlanSummaryRequest{Dir: "environments/aws/example", Workspace: "prod"})
+	require.NoError(t, err)
+	require.Equal(t, PlanStats{}, ret.Stats())
+	require.Empty(t, ret.Resources())
+	require.False(t, ret.HasChanges())
+
+	// Only outputs would change, so no resource drifted even though the summary does not say "No changes."
+	c = fakeAtlantis(t, `{"ProjectResults":[{"PlanSuccess":{"TerraformOutput":"Changes to Outputs:\n  ~ endpoint = \"a\" -> \"b\"\n\nYou can apply this plan to save these new output values to the Terraform state, without changing any real infrastructure."}}]}`)
+	ret, err = c.PlanSummary(context.Background(), &PlanSummaryRequest{Dir: "environments/aws/example", Workspace: "prod"})
+	require.NoError(t, err)
+	require.False(t, ret.HasChanges())
+}
+
+func TestClient_PlanSummaryResources(t *testing.T) {
+	c := fakeAtlantis(t, `{"ProjectResults":[`+
+		`{"PlanSuccess":{"TerraformOutput":"  # aws_instance.web will be created\n\nPlan: 1 to add, 0 to change, 0 to destroy."}},`+
+		`{"PlanSuccess":{"TerraformOutput":"  # aws_iam_role.old will be destroyed\n\nPlan: 0 to add, 0 to change, 1 to destroy."}}]}`)
+	ret, err := c.PlanSummary(context.Background(), &PlanSummaryRequest{Dir: "environments/aws/example", Workspace: "prod"})
+	require.NoError(t, err)
+	require.Equal(t, []ResourceChange{
+		{Address: "aws_instance.web", Action: ActionCreate},
+		{Address: "aws_iam_role.old", Action: ActionDelete},
+	}, ret.Resources())
+}
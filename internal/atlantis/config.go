@@ -11,7 +11,22 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-type DirectoriesWithWorkspaces map[string][]string
+// Workspace is a workspace atlantis plans inside a directory
+type Workspace struct {
+	Name string
+	// The atlantis project name, if atlantis.yaml gives the project one
+	ProjectName string
+}
+
+// RemoteWorkspaceName is the name of workspace in the remote backend.  atlantis treats an unset workspace as default.
+func RemoteWorkspaceName(workspace string) string {
+	if workspace == "" {
+		return "default"
+	}
+	return workspace
+}
+
+type DirectoriesWithWorkspaces map[string][]Workspace
 
 func (d DirectoriesWithWorkspaces) SortedKeys() []string {
 	keys := make([]string, 0, len(d))
@@ -26,9 +41,12 @@ func ConfigToWorkspaces(cfg *SimpleAtlantisConfig) DirectoriesWithWorkspaces {
 	workspaces := make(DirectoriesWithWorkspaces)
 	for _, p := range cfg.Projects {
 		if _, exists := workspaces[p.Dir]; !exists {
-			workspaces[p.Dir] = []string{}
+			workspaces[p.Dir] = []Workspace{}
 		}
-		workspaces[p.Dir] = append(workspaces[p.Dir], p.Workspace)
+		workspaces[p.Dir] = append(workspaces[p.Dir], Workspace{
+			Name:        p.Workspace,
+			ProjectName: p.GetName(),
+		})
 	}
 	return workspaces
 }
@@ -54,6 +54,14 @@ func TestParseRepoConfig(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestConfigToWorkspaces(t *testing.T) {
+	cfg, err := ParseRepoConfig(exampleFromGithubIssue)
+	require.NoError(t, err)
+	ws := ConfigToWorkspaces(cfg)
+	require.Equal(t, []string{"components/terraform/cloudtrail"}, ws.SortedKeys())
+	require.Equal(t, []Workspace{{Name: "pepe-ue2-lab", ProjectName: "pepe-ue2-lab-cloudtrail"}}, ws["components/terraform/cloudtrail"])
+}
+
 func TestParseRepoConfigFromDir(t *testing.T) {
 	dirName, err := os.MkdirTemp("", "config-test")
 	require.NoError(t, err)
@@ -69,3 +77,8 @@ func TestParseRepoConfigFromDir(t *testing.T) {
 	require.Equal(t, 3, len(cfg.Projects))
 	require.Equal(t, "environments/aws/example", cfg.Projects[0].Dir)
 }
+
+func TestRemoteWorkspaceName(t *testing.T) {
+	require.Equal(t, "default", RemoteWorkspaceName(""))
+	require.Equal(t, "prod", RemoteWorkspaceName("prod"))
+}
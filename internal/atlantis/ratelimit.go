@@ -0,0 +1,106 @@
+package atlantis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter is a token bucket for atlantis requests that slows itself down when atlantis keeps returning 503
+type RateLimiter struct {
+	limiter *rate.Limiter
+	// The configured limit, which we recover back to after slowing down
+	base rate.Limit
+	// How many 503 responses in a row before the limit is halved
+	slowdownAfter int
+	// Never slow down below this
+	minLimit rate.Limit
+
+	mu                     sync.Mutex
+	consecutiveUnavailable int
+}
+
+// NewRateLimiter allows limit requests per second, with bursts of up to burst requests.  After slowdownAfter 503
+// responses in a row the limit is halved (never below a tenth of limit), then doubled back on each success.
+func NewRateLimiter(limit rate.Limit, burst int, slowdownAfter int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		limiter:       rate.NewLimiter(limit, burst),
+		base:          limit,
+		slowdownAfter: slowdownAfter,
+		minLimit:      limit / 10,
+	}
+}
+
+// ParseRate parses a rate like "10/s", "30/m" or "100/h".  A bare number is per second.
+func ParseRate(s string) (rate.Limit, error) {
+	count, per, found := strings.Cut(s, "/")
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %s: %w", s, err)
+	}
+	if math.IsNaN(n) || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %s: must be positive", s)
+	}
+	if !found {
+		return rate.Limit(n), nil
+	}
+	switch per {
+	case "s":
+		return rate.Limit(n), nil
+	case "m":
+		return rate.Limit(n / 60), nil
+	case "h":
+		return rate.Limit(n / 3600), nil
+	}
+	return 0, fmt.Errorf("invalid rate %s: unit must be s, m or h", s)
+}
+
+// Wait blocks until a request is allowed, returning how long it waited
+func (r *RateLimiter) Wait(ctx context.Context) (time.Duration, error) {
+	if r == nil {
+		return 0, nil
+	}
+	start := time.Now()
+	err := r.limiter.Wait(ctx)
+	return time.Since(start), err
+}
+
+// Limit is the number of requests per second currently allowed
+func (r *RateLimiter) Limit() rate.Limit {
+	if r == nil {
+		return rate.Inf
+	}
+	return r.limiter.Limit()
+}
+
+// observe adjusts the limit based on the status code of an atlantis response
+func (r *RateLimiter) observe(statusCode int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current := r.limiter.Limit()
+	if statusCode == http.StatusServiceUnavailable {
+		r.consecutiveUnavailable++
+		if r.slowdownAfter > 0 && r.consecutiveUnavailable >= r.slowdownAfter {
+			r.consecutiveUnavailable = 0
+			r.limiter.SetLimit(max(current/2, r.minLimit))
+		}
+		return
+	}
+	r.consecutiveUnavailable = 0
+	if current < r.base {
+		r.limiter.SetLimit(min(current*2, r.base))
+	}
+}
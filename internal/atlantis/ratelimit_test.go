@@ -0,0 +1,71 @@
+package atlantis
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestParseRate(t *testing.T) {
+	r, err := ParseRate("10/s")
+	require.NoError(t, err)
+	require.Equal(t, rate.Limit(10), r)
+	r, err = ParseRate("2.5")
+	require.NoError(t, err)
+	require.Equal(t, rate.Limit(2.5), r)
+	r, err = ParseRate("30/m")
+	require.NoError(t, err)
+	require.Equal(t, rate.Every(2*time.Second), r)
+	r, err = ParseRate("60/h")
+	require.NoError(t, err)
+	require.InDelta(t, float64(rate.Every(time.Minute)), float64(r), 1e-12)
+	r, err = ParseRate("0.5/m")
+	require.NoError(t, err)
+	require.Equal(t, rate.Every(2*time.Minute), r)
+	r, err = ParseRate("1.5/m")
+	require.NoError(t, err)
+	require.Equal(t, rate.Limit(0.025), r)
+	r, err = ParseRate("0.5/h")
+	require.NoError(t, err)
+	require.InDelta(t, float64(rate.Every(2*time.Hour)), float64(r), 1e-12)
+	_, err = ParseRate("10/d")
+	require.Error(t, err)
+	_, err = ParseRate("-1/s")
+	require.Error(t, err)
+	_, err = ParseRate("0/m")
+	require.Error(t, err)
+	_, err = ParseRate("NaN/h")
+	require.Error(t, err)
+	_, err = ParseRate("fast")
+	require.Error(t, err)
+}
+
+func TestRateLimiter_observe(t *testing.T) {
+	r := NewRateLimiter(8, 1, 2)
+	r.observe(http.StatusServiceUnavailable)
+	require.Equal(t, rate.Limit(8), r.Limit())
+	r.observe(http.StatusServiceUnavailable)
+	require.Equal(t, rate.Limit(4), r.Limit())
+	for i := 0; i < 20; i++ {
+		r.observe(http.StatusServiceUnavailable)
+	}
+	require.InDelta(t, 0.8, float64(r.Limit()), 0.0001)
+	r.observe(http.StatusOK)
+	require.InDelta(t, 1.6, float64(r.Limit()), 0.0001)
+	for i := 0; i < 10; i++ {
+		r.observe(http.StatusOK)
+	}
+	require.Equal(t, rate.Limit(8), r.Limit())
+}
+
+func TestRateLimiter_Nil(t *testing.T) {
+	var r *RateLimiter
+	waited, err := r.Wait(t.Context())
+	require.NoError(t, err)
+	require.Equal(t, time.Duration(0), waited)
+	r.observe(http.StatusServiceUnavailable)
+	require.Equal(t, rate.Inf, r.Limit())
+}
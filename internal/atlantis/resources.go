@@ -0,0 +1,67 @@
+package atlantis
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ResourceAction is what a plan would do to a single resource
+type ResourceAction string
+
+const (
+	ActionCreate  ResourceAction = "create"
+	ActionUpdate  ResourceAction = "update"
+	ActionReplace ResourceAction = "replace"
+	ActionDelete  ResourceAction = "delete"
+	ActionRead    ResourceAction = "read"
+	ActionImport  ResourceAction = "import"
+	ActionMove    ResourceAction = "move"
+	// A header this version does not know, from a newer terraform for example.  It does not count towards the Plan
+	// totals, so a plan with one never adds up.
+	ActionUnknown ResourceAction = "unknown"
+)
+
+// ResourceChange is one resource a plan would change
+type ResourceChange struct {
+	Address string
+	Action  ResourceAction
+}
+
+// The header terraform prints above every resource in the plan, like "  # aws_instance.web will be created".  Every
+// "will ..." and "must ..." header matches, so that new reasons, like "will be replaced due to changes in
+// replace_triggered_by", are not missed.  Notes like "# (because ...)" start with a parenthesis and never match.
+var resourceHeader = regexp.MustCompile(`(?m)^\s*# ([^(\s].*?)(?: \(deposed object \w+\))? ((?:will|must) .+|has moved to .+)$`)
+
+// parseResourceChanges finds every resource change in the output of terraform plan.  Objects that only changed
+// outside of Terraform are not listed, since the plan does nothing to them.
+func parseResourceChanges(output string) []ResourceChange {
+	var ret []ResourceChange
+	for _, m := range resourceHeader.FindAllStringSubmatch(output, -1) {
+		ret = append(ret, ResourceChange{
+			Address: m[1],
+			Action:  resourceAction(m[2]),
+		})
+	}
+	return ret
+}
+
+func resourceAction(header string) ResourceAction {
+	switch {
+	case header == "will be created":
+		return ActionCreate
+	case header == "will be destroyed":
+		return ActionDelete
+	case header == "will be updated in-place":
+		return ActionUpdate
+	case header == "will be read during apply":
+		return ActionRead
+	case header == "will be imported":
+		return ActionImport
+	case strings.HasPrefix(header, "has moved to "):
+		return ActionMove
+	case strings.Contains(header, "replaced"):
+		return ActionReplace
+	default:
+		return ActionUnknown
+	}
+}
@@ -0,0 +1,73 @@
+package atlantis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const resourcesPlanOutput = `Note: Objects have changed outside of Terraform
+
+Terraform detected the following changes made outside of Terraform since the
+last "terraform apply":
+
+  # aws_s3_bucket.logs has changed
+  ~ resource "aws_s3_bucket" "logs" {
+      ~ tags = {
+          + "owner" = "controller"
+        }
+    }
+
+Terraform will perform the following actions:
+
+  # aws_instance.web will be created
+  + resource "aws_instance" "web" {
+      + ami = "ami-123"
+    }
+
+  # module.vpc.aws_subnet.private["us-east-1a"] will be updated in-place
+  ~ resource "aws_subnet" "private" {
+      ~ map_public_ip_on_launch = true -> false
+    }
+
+  # aws_db_instance.main must be replaced
+-/+ resource "aws_db_instance" "main" {
+    }
+
+  # aws_iam_role.old will be destroyed
+  # (because aws_iam_role.old is not in configuration)
+  - resource "aws_iam_role" "old" {
+    }
+
+  # aws_security_group.a has moved to aws_security_group.b
+    resource "aws_security_group" "b" {
+    }
+
+  # aws_lambda_function.api will be replaced due to changes in replace_triggered_by
+-/+ resource "aws_lambda_function" "api" {
+    }
+
+  # aws_instance.old (deposed object 1a2b3c4d) will be destroyed
+  - resource "aws_instance" "old" {
+    }
+
+  # aws_iam_policy.legacy will no longer be managed by Terraform
+    resource "aws_iam_policy" "legacy" {
+    }
+
+Plan: 2 to add, 1 to change, 4 to destroy.
+`
+
+func TestParseResourceChanges(t *testing.T) {
+	require.Equal(t, []ResourceChange{
+		{Address: "aws_instance.web", Action: ActionCreate},
+		{Address: `module.vpc.aws_subnet.private["us-east-1a"]`, Action: ActionUpdate},
+		{Address: "aws_db_instance.main", Action: ActionReplace},
+		{Address: "aws_iam_role.old", Action: ActionDelete},
+		{Address: "aws_security_group.a", Action: ActionMove},
+		{Address: "aws_lambda_function.api", Action: ActionReplace},
+		{Address: "aws_instance.old", Action: ActionDelete},
+		{Address: "aws_iam_policy.legacy", Action: ActionUnknown},
+	}, parseResourceChanges(resourcesPlanOutput))
+	require.Empty(t, parseResourceChanges("No changes. Your infrastructure matches the configuration."))
+}
@@ -1,13 +1,31 @@
 package atlantisgithub
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cresta/atlantis-drift-detection/internal/tracing"
 	"github.com/cresta/gogit"
 	"github.com/cresta/gogithub"
+	"github.com/cresta/pipe"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const tracerName = "github.com/cresta/atlantis-drift-detection/internal/atlantisgithub"
+
 func CheckOutTerraformRepo(ctx context.Context, gitHubClient gogithub.GitHub, cloner *gogit.Cloner, repo string) (*gogit.Repository, error) {
+	ctx, span := tracing.Tracer(tracerName).Start(ctx, "CheckOutTerraformRepo", trace.WithAttributes(attribute.String("drift.repo", repo)))
+	defer span.End()
+	ret, err := checkOutTerraformRepo(ctx, gitHubClient, cloner, repo)
+	tracing.RecordError(span, err)
+	return ret, err
+}
+
+func checkOutTerraformRepo(ctx context.Context, gitHubClient gogithub.GitHub, cloner *gogit.Cloner, repo string) (*gogit.Repository, error) {
 	token, err := gitHubClient.GetAccessToken(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get access token: %w", err)
@@ -20,3 +38,37 @@ func CheckOutTerraformRepo(ctx context.Context, gitHubClient gogithub.GitHub, cl
 	}
 	return repository, nil
 }
+
+// UpdateTerraformRepo brings an existing checkout of repo up to date with its default branch, dropping any local
+// changes.  It fetches with a fresh access token, since the one used to clone may have expired.
+func UpdateTerraformRepo(ctx context.Context, gitHubClient gogithub.GitHub, checkout *gogit.Repository, repo string) error {
+	ctx, span := tracing.Tracer(tracerName).Start(ctx, "UpdateTerraformRepo", trace.WithAttributes(attribute.String("drift.repo", repo)))
+	defer span.End()
+	err := updateTerraformRepo(ctx, gitHubClient, checkout, repo)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func updateTerraformRepo(ctx context.Context, gitHubClient gogithub.GitHub, checkout *gogit.Repository, repo string) error {
+	token, err := gitHubClient.GetAccessToken(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get access token: %w", err)
+	}
+	githubRepoURL := fmt.Sprintf("https://x-access-token:%s@github.com/%s.git", token, repo)
+	// The fetch URL holds the token, so it is left out of errors
+	steps := []struct {
+		name string
+		args []string
+	}{
+		{"fetch", []string{"fetch", githubRepoURL, "HEAD"}},
+		{"reset", []string{"reset", "--hard", "FETCH_HEAD"}},
+		{"clean", []string{"clean", "-ffd"}},
+	}
+	for _, s := range steps {
+		var stderr bytes.Buffer
+		if err := pipe.NewPiped("git", s.args...).WithDir(checkout.Location()).Execute(ctx, nil, io.Discard, &stderr); err != nil {
+			return fmt.Errorf("failed to %s repo %s: %w: %s", s.name, repo, err, strings.ReplaceAll(stderr.String(), token, "***"))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/drifter"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+)
+
+type Status string
+
+const (
+	StatusNeverRun Status = "never_run"
+	StatusRunning  Status = "running"
+	StatusSuccess  Status = "success"
+	StatusFailed   Status = "failed"
+)
+
+// RunStatus describes the most recent drift run
+type RunStatus struct {
+	Status Status    `json:"status"`
+	Start  time.Time `json:"start,omitzero"`
+	End    time.Time `json:"end,omitzero"`
+	Error  string    `json:"error,omitempty"`
+	// When the next run is scheduled
+	Next time.Time `json:"next,omitzero"`
+}
+
+// Daemon runs drift checks on a cron schedule inside one long-lived process
+type Daemon struct {
+	// Runs a single drift check, usually Drifter.Drift
+	Drift    func(ctx context.Context) (*drifter.Report, error)
+	Schedule cron.Schedule
+	Logger   *zap.Logger
+	// If true, run once right away instead of waiting for the first scheduled time
+	RunOnStart bool
+	// Called with the report of every finished run
+	OnReport func(report *drifter.Report)
+
+	mu         sync.Mutex
+	lastRun    RunStatus
+	lastReport *drifter.Report
+}
+
+// ParseSchedule parses a standard 5 field cron expression, or a descriptor like @hourly
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	return cron.ParseStandard(spec)
+}
+
+// Run blocks, running a drift check at every scheduled time, until ctx is done.  Runs never overlap: scheduled times
+// that pass while a run is still going are skipped.
+func (d *Daemon) Run(ctx context.Context) error {
+	if d.RunOnStart {
+		d.runOnce(ctx)
+	}
+	for {
+		next := d.Schedule.Next(time.Now())
+		d.setNext(next)
+		d.Logger.Info("Waiting for next scheduled run", zap.Time("next", next))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		d.runOnce(ctx)
+	}
+}
+
+func (d *Daemon) runOnce(ctx context.Context) {
+	d.mu.Lock()
+	d.lastRun = RunStatus{
+		Status: StatusRunning,
+		Start:  time.Now(),
+	}
+	d.mu.Unlock()
+	d.Logger.Info("Starting scheduled drift run")
+	report, err := d.Drift(ctx)
+	d.mu.Lock()
+	d.lastRun.End = time.Now()
+	if err != nil {
+		d.lastRun.Status = StatusFailed
+		d.lastRun.Error = err.Error()
+	} else {
+		d.lastRun.Status = StatusSuccess
+	}
+	if report != nil {
+		d.lastReport = report
+	}
+	d.mu.Unlock()
+	if err != nil {
+		d.Logger.Error("Scheduled drift run failed", zap.Error(err))
+	} else {
+		d.Logger.Info("Scheduled drift run finished")
+	}
+	if report != nil && d.OnReport != nil {
+		d.OnReport(report)
+	}
+}
+
+func (d *Daemon) setNext(next time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastRun.Next = next
+}
+
+// LastRun returns the status of the most recent (or currently running) drift run
+func (d *Daemon) LastRun() RunStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.lastRun.Status == "" {
+		return RunStatus{Status: StatusNeverRun, Next: d.lastRun.Next}
+	}
+	return d.lastRun
+}
+
+// Handler serves the daemon status endpoints
+func (d *Daemon) Handler() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, d.LastRun())
+	})
+	mux.HandleFunc("GET /report", func(w http.ResponseWriter, _ *http.Request) {
+		d.mu.Lock()
+		report := d.lastReport
+		d.mu.Unlock()
+		if report == nil {
+			http.Error(w, "no finished run yet", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, report)
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
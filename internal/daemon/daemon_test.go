@@ -0,0 +1,148 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/drifter"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestParseSchedule(t *testing.T) {
+	s, err := ParseSchedule("0 * * * *")
+	require.NoError(t, err)
+	start := time.Date(2022, 1, 1, 10, 30, 0, 0, time.UTC)
+	require.Equal(t, time.Date(2022, 1, 1, 11, 0, 0, 0, time.UTC), s.Next(start))
+	_, err = ParseSchedule("not a schedule")
+	require.Error(t, err)
+}
+
+func TestDaemon_RunStopsOnCancel(t *testing.T) {
+	s, err := ParseSchedule("@yearly")
+	require.NoError(t, err)
+	d := Daemon{
+		Schedule: s,
+		Logger:   zaptest.NewLogger(t),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, d.Run(ctx), context.DeadlineExceeded)
+
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/status")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, resp.Body.Close())
+	}()
+	var status RunStatus
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	require.Equal(t, StatusNeverRun, status.Status)
+	require.Equal(t, s.Next(time.Now()).Year(), status.Next.Year())
+
+	reportResp, err := http.Get(srv.URL + "/report")
+	require.NoError(t, err)
+	require.NoError(t, reportResp.Body.Close())
+	require.Equal(t, http.StatusNotFound, reportResp.StatusCode)
+}
+
+func getStatus(t *testing.T, srv *httptest.Server) RunStatus {
+	resp, err := http.Get(srv.URL + "/status")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, resp.Body.Close())
+	}()
+	var status RunStatus
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	return status
+}
+
+func TestDaemon_runOnce(t *testing.T) {
+	started := make(chan struct{})
+	finish := make(chan error)
+	var reported []*drifter.Report
+	d := Daemon{
+		Logger: zaptest.NewLogger(t),
+		Drift: func(ctx context.Context) (*drifter.Report, error) {
+			close(started)
+			return &drifter.Report{Repo: "cresta/terraform"}, <-finish
+		},
+		OnReport: func(report *drifter.Report) {
+			reported = append(reported, report)
+		},
+	}
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.runOnce(context.Background())
+	}()
+	<-started
+	status := getStatus(t, srv)
+	require.Equal(t, StatusRunning, status.Status)
+	require.False(t, status.Start.IsZero())
+	require.True(t, status.End.IsZero())
+	finish <- nil
+	<-done
+
+	status = getStatus(t, srv)
+	require.Equal(t, StatusSuccess, status.Status)
+	require.False(t, status.End.Before(status.Start))
+	require.Len(t, reported, 1)
+	resp, err := http.Get(srv.URL + "/report")
+	require.NoError(t, err)
+	var report drifter.Report
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, "cresta/terraform", report.Repo)
+
+	started = make(chan struct{})
+	go func() {
+		finish <- errors.New("atlantis is down")
+	}()
+	d.runOnce(context.Background())
+	status = d.LastRun()
+	require.Equal(t, StatusFailed, status.Status)
+	require.Equal(t, "atlantis is down", status.Error)
+	require.Len(t, reported, 2)
+}
+
+// everyTick is a schedule that fires every interval
+type everyTick time.Duration
+
+func (e everyTick) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+func TestDaemon_RunDoesNotOverlap(t *testing.T) {
+	var running, maxRunning, runs atomic.Int32
+	d := Daemon{
+		Logger:     zaptest.NewLogger(t),
+		Schedule:   everyTick(time.Millisecond),
+		RunOnStart: true,
+		Drift: func(ctx context.Context) (*drifter.Report, error) {
+			now := running.Add(1)
+			defer running.Add(-1)
+			if now > maxRunning.Load() {
+				maxRunning.Store(now)
+			}
+			runs.Add(1)
+			time.Sleep(10 * time.Millisecond)
+			return &drifter.Report{}, nil
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, d.Run(ctx), context.DeadlineExceeded)
+	require.GreaterOrEqual(t, runs.Load(), int32(2))
+	require.Equal(t, int32(1), maxRunning.Load())
+}
@@ -0,0 +1,107 @@
+package drifter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
+)
+
+// DefaultAcknowledgmentsFile is where acknowledgments are checked in, relative to the root of the terraform repo
+const DefaultAcknowledgmentsFile = ".drift-acknowledgments.yaml"
+
+// Acknowledgment is drift someone knows about.  Drift notifications for it stop until it expires.
+type Acknowledgment struct {
+	Dir string `yaml:"dir"`
+	// Empty acknowledges every workspace of Dir
+	Workspace string `yaml:"workspace"`
+	// Why the drift is expected
+	Reason string `yaml:"reason"`
+	// Who acknowledged the drift
+	By      string    `yaml:"by"`
+	Expires time.Time `yaml:"expires"`
+}
+
+func (a *Acknowledgment) String() string {
+	ret := "acknowledged"
+	if a.By != "" {
+		ret += " by " + a.By
+	}
+	ret += " until " + a.Expires.Format(time.RFC3339)
+	if a.Reason != "" {
+		ret += ": " + a.Reason
+	}
+	return ret
+}
+
+type acknowledgmentsFile struct {
+	// Pointers, so that an empty entry decodes to nil instead of disappearing
+	Acknowledgments []*Acknowledgment `yaml:"acknowledgments"`
+}
+
+// ParseAcknowledgments reads an acknowledgments file.  A file that does not exist has no acknowledgments.
+func ParseAcknowledgments(filename string) ([]Acknowledgment, error) {
+	body, err := os.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read acknowledgments file %s: %w", filename, err)
+	}
+	var ret acknowledgmentsFile
+	if err := decodeStrictYAML(body, &ret); err != nil {
+		return nil, fmt.Errorf("failed to parse acknowledgments file %s: %w", filename, err)
+	}
+	acks := make([]Acknowledgment, 0, len(ret.Acknowledgments))
+	for i, a := range ret.Acknowledgments {
+		if a == nil {
+			return nil, fmt.Errorf("acknowledgment %d in %s is empty", i, filename)
+		}
+		if a.Dir == "" {
+			return nil, fmt.Errorf("acknowledgment %d in %s has no dir", i, filename)
+		}
+		if a.Expires.IsZero() {
+			return nil, fmt.Errorf("acknowledgment of %s in %s has no expiry", a.Dir, filename)
+		}
+		acks = append(acks, *a)
+	}
+	return acks, nil
+}
+
+// acknowledgment returns the unexpired acknowledgment of dir/workspace that lasts the longest, from either the
+// acknowledgments file or the cache.  It returns nil if the drift is not acknowledged.
+func (d *Drifter) acknowledgment(ctx context.Context, dir string, workspace string) (*Acknowledgment, error) {
+	now := time.Now()
+	var ret *Acknowledgment
+	consider := func(a *Acknowledgment) {
+		if now.Before(a.Expires) && (ret == nil || a.Expires.After(ret.Expires)) {
+			ret = a
+		}
+	}
+	for i := range d.acknowledgments {
+		a := &d.acknowledgments[i]
+		if a.Dir == dir && (a.Workspace == "" || a.Workspace == workspace) {
+			consider(a)
+		}
+	}
+	val, err := d.ResultCache.GetAcknowledgment(ctx, &processedcache.ConsiderDriftChecked{
+		Dir:       dir,
+		Workspace: workspace,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get acknowledgment for %s/%s: %w", dir, workspace, err)
+	}
+	if val != nil {
+		consider(&Acknowledgment{
+			Dir:       dir,
+			Workspace: workspace,
+			Reason:    val.Reason,
+			By:        val.By,
+			Expires:   val.Expires,
+		})
+	}
+	return ret, nil
+}
@@ -0,0 +1,75 @@
+package drifter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAcknowledgments(t *testing.T) {
+	td := t.TempDir()
+	acks, err := ParseAcknowledgments(filepath.Join(td, DefaultAcknowledgmentsFile))
+	require.NoError(t, err)
+	require.Empty(t, acks)
+
+	filename := filepath.Join(td, "acks.yaml")
+	require.NoError(t, os.WriteFile(filename, []byte(`acknowledgments:
+  - dir: environments/aws/example
+    workspace: prod
+    reason: waiting on the vendor
+    by: platform-team
+    expires: 2030-01-02
+`), 0644))
+	acks, err = ParseAcknowledgments(filename)
+	require.NoError(t, err)
+	require.Len(t, acks, 1)
+	require.Equal(t, "environments/aws/example", acks[0].Dir)
+	require.Equal(t, "prod", acks[0].Workspace)
+	require.Equal(t, time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC), acks[0].Expires)
+	require.Equal(t, "acknowledged by platform-team until 2030-01-02T00:00:00Z: waiting on the vendor", acks[0].String())
+
+	require.NoError(t, os.WriteFile(filename, []byte("acknowledgments:\n  - dir: environments/aws/example\n"), 0644))
+	_, err = ParseAcknowledgments(filename)
+	require.Error(t, err)
+	require.NoError(t, os.WriteFile(filename, []byte("acknowledgments:\n  -\n"), 0644))
+	_, err = ParseAcknowledgments(filename)
+	require.Error(t, err)
+	// A misspelled workspace would otherwise acknowledge every workspace of the directory
+	require.NoError(t, os.WriteFile(filename, []byte("acknowledgments:\n  - dir: environments/aws/example\n    worksapce: prod\n    expires: 2030-01-02\n"), 0644))
+	_, err = ParseAcknowledgments(filename)
+	require.ErrorContains(t, err, "worksapce")
+}
+
+func TestDrifter_acknowledgment(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	d := Drifter{
+		ResultCache: &processedcache.Memory{},
+		acknowledgments: []Acknowledgment{
+			{Dir: "a", Expires: now.Add(time.Hour), Reason: "whole directory"},
+			{Dir: "b", Workspace: "prod", Expires: now.Add(-time.Hour), Reason: "expired"},
+		},
+	}
+	ack, err := d.acknowledgment(ctx, "a", "prod")
+	require.NoError(t, err)
+	require.Equal(t, "whole directory", ack.Reason)
+	ack, err = d.acknowledgment(ctx, "b", "prod")
+	require.NoError(t, err)
+	require.Nil(t, ack)
+
+	require.NoError(t, d.ResultCache.StoreAcknowledgment(ctx, &processedcache.ConsiderDriftChecked{Dir: "a", Workspace: "prod"}, &processedcache.AcknowledgmentValue{
+		Reason:  "from the cache",
+		Expires: now.Add(2 * time.Hour),
+	}))
+	ack, err = d.acknowledgment(ctx, "a", "prod")
+	require.NoError(t, err)
+	require.Equal(t, "from the cache", ack.Reason)
+	ack, err = d.acknowledgment(ctx, "a", "dev")
+	require.NoError(t, err)
+	require.Equal(t, "whole directory", ack.Reason)
+}
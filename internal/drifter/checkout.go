@@ -0,0 +1,53 @@
+package drifter
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantisgithub"
+	"github.com/cresta/gogit"
+	"go.uber.org/zap"
+)
+
+// checkOut returns a checkout of the latest Repo.  With KeepCheckout, the checkout of the previous run is updated
+// instead of cloning the repo again.
+func (d *Drifter) checkOut(ctx context.Context) (*gogit.Repository, error) {
+	if d.KeepCheckout && d.checkout != nil {
+		err := atlantisgithub.UpdateTerraformRepo(ctx, d.GithubClient, d.checkout, d.Repo)
+		if err == nil {
+			return d.checkout, nil
+		}
+		d.Logger.Warn("failed to update checkout, cloning again", zap.Error(err))
+		d.RemoveCheckout()
+	}
+	repo, err := atlantisgithub.CheckOutTerraformRepo(ctx, d.GithubClient, d.Cloner, d.Repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to checkout repo %s: %w", d.Repo, err)
+	}
+	if d.KeepCheckout {
+		d.checkout = repo
+	}
+	return repo, nil
+}
+
+// doneWithCheckout removes repo at the end of a run, unless it is kept for the next one
+func (d *Drifter) doneWithCheckout(repo *gogit.Repository) {
+	if d.KeepCheckout {
+		return
+	}
+	if err := os.RemoveAll(repo.Location()); err != nil {
+		d.Logger.Warn("failed to cleanup repo", zap.Error(err))
+	}
+}
+
+// RemoveCheckout removes the checkout kept between runs with KeepCheckout, if there is one
+func (d *Drifter) RemoveCheckout() {
+	if d.checkout == nil {
+		return
+	}
+	if err := os.RemoveAll(d.checkout.Location()); err != nil {
+		d.Logger.Warn("failed to cleanup repo", zap.Error(err))
+	}
+	d.checkout = nil
+}
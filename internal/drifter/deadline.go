@@ -0,0 +1,56 @@
+package drifter
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	deadlineReason = "run deadline reached"
+	shutdownReason = "shutting down"
+)
+
+// withWorkspaceTimeout bounds a single check by WorkspaceTimeout, if one is set
+func (d *Drifter) withWorkspaceTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.WorkspaceTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d.WorkspaceTimeout)
+}
+
+// timedOut returns true if checkCtx hit its own timeout, rather than parent being cancelled
+func timedOut(parent context.Context, checkCtx context.Context) bool {
+	return parent.Err() == nil && checkCtx.Err() == context.DeadlineExceeded
+}
+
+// runDeadline returns when a run started at start should stop scheduling new checks, or the zero time if never
+func (d *Drifter) runDeadline(start time.Time) time.Time {
+	if d.RunDeadline <= 0 {
+		return time.Time{}
+	}
+	return start.Add(d.RunDeadline)
+}
+
+// withGracePeriod returns a context that is not cancelled with ctx, but grace after it.  Without a grace period it is
+// cancelled with ctx, and checkAll reports whatever never started as not checked.
+func withGracePeriod(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	if grace <= 0 {
+		return context.WithCancel(ctx)
+	}
+	workCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	go func() {
+		select {
+		case <-workCtx.Done():
+			return
+		case <-ctx.Done():
+		}
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-workCtx.Done():
+		case <-timer.C:
+			cancel()
+		}
+	}()
+	return workCtx, cancel
+}
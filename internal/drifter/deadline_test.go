@@ -0,0 +1,86 @@
+package drifter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrifter_withWorkspaceTimeout(t *testing.T) {
+	d := Drifter{WorkspaceTimeout: time.Millisecond}
+	parent := context.Background()
+	checkCtx, cancel := d.withWorkspaceTimeout(parent)
+	defer cancel()
+	<-checkCtx.Done()
+	require.True(t, timedOut(parent, checkCtx))
+
+	parent, cancelParent := context.WithCancel(context.Background())
+	d.WorkspaceTimeout = 0
+	checkCtx, cancel = d.withWorkspaceTimeout(parent)
+	defer cancel()
+	_, hasDeadline := checkCtx.Deadline()
+	require.False(t, hasDeadline)
+	cancelParent()
+	<-checkCtx.Done()
+	require.False(t, timedOut(parent, checkCtx))
+}
+
+func TestReport_stopReason(t *testing.T) {
+	var d Drifter
+	r := Report{Deadline: d.runDeadline(time.Now())}
+	require.Empty(t, r.stopReason())
+	d.RunDeadline = time.Hour
+	r.Deadline = d.runDeadline(time.Now().Add(-2 * time.Hour))
+	require.Equal(t, deadlineReason, r.stopReason())
+	r.Deadline = d.runDeadline(time.Now())
+	require.Empty(t, r.stopReason())
+	r.stopScheduling(shutdownReason)
+	require.Equal(t, shutdownReason, r.stopReason())
+}
+
+func TestWithGracePeriod(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	workCtx, cancelWork := withGracePeriod(ctx, 50*time.Millisecond)
+	defer cancelWork()
+	cancel()
+	require.NoError(t, workCtx.Err())
+	<-workCtx.Done()
+	require.ErrorIs(t, workCtx.Err(), context.Canceled)
+
+	workCtx, cancelWork = withGracePeriod(ctx, 0)
+	defer cancelWork()
+	require.Error(t, workCtx.Err())
+}
+
+func TestDrifter_checkAllShutdownWithoutGracePeriod(t *testing.T) {
+	for _, parallel := range []int{1, 3} {
+		fake := &fakeAtlantis{}
+		fake.setBody(changesResult)
+		d := testDrifter(t, fake, &recordingNotification{})
+		d.ParallelRuns = parallel
+		ws := atlantis.DirectoriesWithWorkspaces{
+			"a": {{Name: "dev"}, {Name: "prod"}},
+			"b": {{Name: "prod", ProjectName: "app"}, {Name: "prod", ProjectName: "db"}},
+		}
+		// Without a grace period the work context is done as soon as the shutdown starts
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		report := &Report{}
+		require.Error(t, d.checkAll(ctx, ws, report))
+		require.Len(t, report.Workspaces, 4, "parallel %d", parallel)
+		for _, w := range report.Workspaces {
+			if w.Outcome != OutcomeNotChecked {
+				require.NotEmpty(t, w.Error, "%s#%s", w.Dir, w.Workspace)
+			}
+		}
+		require.Equal(t, shutdownReason, report.stopReason())
+		require.Len(t, report.Directories, 2)
+		for _, dir := range report.Directories {
+			require.Equal(t, OutcomeNotChecked, dir.Outcome)
+			require.Equal(t, shutdownReason, dir.Reason)
+		}
+	}
+}
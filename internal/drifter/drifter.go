@@ -5,15 +5,19 @@ import (
 	"errors"
 	"fmt"
 	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
-	"github.com/cresta/atlantis-drift-detection/internal/atlantisgithub"
+	"github.com/cresta/atlantis-drift-detection/internal/filter"
+	"github.com/cresta/atlantis-drift-detection/internal/metrics"
 	"github.com/cresta/atlantis-drift-detection/internal/notification"
 	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
 	"github.com/cresta/atlantis-drift-detection/internal/terraform"
+	"github.com/cresta/atlantis-drift-detection/internal/tracing"
 	"github.com/cresta/gogit"
 	"github.com/cresta/gogithub"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
-	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -28,45 +32,242 @@ type Drifter struct {
 	ResultCache        processedcache.ProcessedCache
 	CacheValidDuration time.Duration
 	DirectoryWhitelist []string
+	// Include/exclude patterns for directories, applied after DirectoryWhitelist
+	DirectoryFilter *filter.Filter
+	// Include/exclude patterns for workspaces
+	WorkspaceFilter *filter.Filter
+	// Include/exclude patterns for atlantis project names
+	ProjectFilter *filter.Filter
+	// Remote workspaces that are never reported as extra
+	IgnoredRemoteWorkspaces []*filter.LocationRule
+	// If true, the default workspace is only expected in directories where atlantis uses it.  Anywhere else it is
+	// reported as extra if it holds any state.
+	CheckUnusedDefaultWorkspace bool
+
 	SkipWorkspaceCheck bool
 	ParallelRuns       int
+	// If true, a failed check is recorded in the report and the run moves on to the next one
+	ContinueOnError bool
+	// When ContinueOnError is set, the number of failed checks tolerated before the run as a whole fails.
+	// A negative budget never fails the run.
+	ErrorBudget int
+	// How many times to ask atlantis for a plan that fails with a temporary error.  Values below 2 never retry.
+	PlanMaxAttempts int
+	// The delay before the first retry, which doubles (with jitter) on each later attempt
+	PlanRetryBackoff time.Duration
+	// The longest we will ever wait between retries
+	PlanRetryMaxBackoff time.Duration
+	// Optional prometheus metrics
+	Metrics *metrics.Metrics
+	// Which slice of the work this process owns when the run is split across multiple jobs
+	Shard Shard
+	// If true, only report what would be checked.  No atlantis calls, terraform commands, cache writes or notifications.
+	DryRun bool
+
+	// Loaded from AcknowledgmentsFile at the start of each run
+	acknowledgments []Acknowledgment
+	// Loaded from IgnoreFile at the start of each run
+	ignoreRules []*IgnoreRule
+	// Reset at the start of each run, and listed the first time the run finds a locked workspace
+	locks *runLocks
+	// The longest a single workspace plan or directory init may take.  Zero means no limit.
+	WorkspaceTimeout time.Duration
+	// How long after the start of a run to stop scheduling new checks.  Zero means no limit.
+	RunDeadline time.Duration
+	// The order to check directories and workspaces in.  Empty is alphabetical.
+	Order Order
+	// What ParallelRuns runs in parallel: whole directories (the default) or single workspaces
+	ParallelUnit ParallelUnit
+	// When ParallelUnit is ParallelWorkspace, the most workspaces of one directory checked at once.  Zero means no limit.
+	MaxParallelPerDirectory int
+	// How often to notify again about drift that has not been resolved.  Zero only notifies when drift first appears.
+	DriftReminderInterval time.Duration
+	// Path, relative to the root of the terraform repo, of the file listing acknowledged drift.  Empty disables it.
+	AcknowledgmentsFile string
+	// Notify when a workspace has been locked by a pull request for longer than this.  Zero never notifies.
+	StaleLockThreshold time.Duration
+	// Once the context passed to Drift is done, how long in-flight checks get to finish before they are cancelled
+	ShutdownGracePeriod time.Duration
+	// How long to wait before checking a failing workspace again, doubling with each failure in a row.  Zero checks
+	// failing workspaces again on every run.
+	FailureBackoff time.Duration
+	// The longest FailureBackoff will grow to.  Zero means no limit.
+	FailureMaxBackoff time.Duration
+	// Notify when a check has failed this many times in a row.  Zero never notifies.
+	FailureNotifyThreshold int
+	// Path, relative to the root of the terraform repo, of the file listing resource changes that are not drift.
+	// Empty disables it.
+	IgnoreFile string
+	// If true, the checkout of the terraform repo is kept between runs and updated with a fetch, rather than cloned
+	// again every run.  Call RemoveCheckout once done.
+	KeepCheckout bool
+	// Only with KeepCheckout: the checkout of the previous run
+	checkout *gogit.Repository
 }
 
-func (d *Drifter) Drift(ctx context.Context) error {
-	repo, err := atlantisgithub.CheckOutTerraformRepo(ctx, d.GithubClient, d.Cloner, d.Repo)
+const tracerName = "github.com/cresta/atlantis-drift-detection/internal/drifter"
+
+func (d *Drifter) Drift(ctx context.Context) (*Report, error) {
+	ctx, span := tracing.Tracer(tracerName).Start(ctx, "Drift", trace.WithAttributes(attribute.String("drift.repo", d.Repo)))
+	defer span.End()
+	report := &Report{
+		Repo:  d.Repo,
+		Start: time.Now(),
+	}
+	report.Deadline = d.runDeadline(report.Start)
+	defer report.finish()
+	d.Metrics.ResetCacheLookups()
+	// In-flight checks, cache writes and notifications keep going on workCtx while ctx only stops new checks
+	workCtx, cancel := withGracePeriod(ctx, d.ShutdownGracePeriod)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		d.Logger.Warn("Shutting down, waiting for in-flight checks", zap.Duration("grace-period", d.ShutdownGracePeriod))
+		report.stopScheduling(shutdownReason)
+	})
+	defer stop()
+	err := d.drift(workCtx, report)
+	if ctx.Err() != nil {
+		report.Interrupted = true
+		err = errors.Join(fmt.Errorf("run interrupted: %w", context.Cause(ctx)), err)
+	}
+	tracing.RecordError(span, err)
+	return report, err
+}
+
+func (d *Drifter) drift(ctx context.Context, report *Report) error {
+	repo, err := d.checkOut(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to checkout repo %s: %w", d.Repo, err)
+		return err
 	}
 	d.Terraform.Directory = repo.Location()
-	defer func() {
-		if err := os.RemoveAll(repo.Location()); err != nil {
-			d.Logger.Warn("failed to cleanup repo", zap.Error(err))
+	defer d.doneWithCheckout(repo)
+	d.acknowledgments = nil
+	if d.AcknowledgmentsFile != "" {
+		d.acknowledgments, err = ParseAcknowledgments(filepath.Join(repo.Location(), d.AcknowledgmentsFile))
+		if err != nil {
+			return fmt.Errorf("failed to load acknowledgments: %w", err)
+		}
+	}
+	d.locks = &runLocks{}
+	d.ignoreRules = nil
+	if d.IgnoreFile != "" {
+		d.ignoreRules, err = ParseIgnoreRules(filepath.Join(repo.Location(), d.IgnoreFile))
+		if err != nil {
+			return fmt.Errorf("failed to load ignore rules: %w", err)
 		}
-	}()
+	}
 	cfg, err := atlantis.ParseRepoConfigFromDir(repo.Location())
 	if err != nil {
 		return fmt.Errorf("failed to parse repo config: %w", err)
 	}
-	workspaces := atlantis.ConfigToWorkspaces(cfg)
-	if err := d.FindDriftedWorkspaces(ctx, workspaces); err != nil {
-		return fmt.Errorf("failed to find drifted workspaces: %w", err)
+	return d.checkAll(ctx, atlantis.ConfigToWorkspaces(cfg), report)
+}
+
+// checkAll checks every workspace and directory of ws, adding the results to report
+func (d *Drifter) checkAll(ctx context.Context, ws atlantis.DirectoriesWithWorkspaces, report *Report) error {
+	err := d.FindDriftedWorkspaces(ctx, ws, report)
+	if err != nil {
+		err = fmt.Errorf("failed to find drifted workspaces: %w", err)
+	} else if err = d.FindExtraWorkspaces(ctx, ws, report); err != nil {
+		err = fmt.Errorf("failed to find extra workspaces: %w", err)
+	}
+	// Once ctx is done the checks stop being scheduled at all, so the ones that never started are reported here
+	if ctx.Err() != nil {
+		report.stopScheduling(shutdownReason)
+	}
+	if reason := report.stopReason(); reason != "" {
+		d.reportNotScheduled(ws, report, reason)
+	}
+	if err != nil {
+		return err
+	}
+	if notChecked := report.CountOutcome(OutcomeNotChecked); notChecked > 0 {
+		d.Logger.Warn("Run stopped before every workspace was checked", zap.Int("not-checked", notChecked), zap.String("reason", report.stopReason()))
 	}
-	if err := d.FindExtraWorkspaces(ctx, workspaces); err != nil {
-		return fmt.Errorf("failed to find extra workspaces: %w", err)
+	return d.checkErrorBudget(report)
+}
+
+// recordFailure adds a failed check to the report.  It returns nil if the run should continue past the failure.
+func (d *Drifter) recordFailure(report *Report, dir string, workspace string, err error) error {
+	report.addFailure(&Failure{
+		Dir:       dir,
+		Workspace: workspace,
+		Error:     err.Error(),
+	})
+	if !d.ContinueOnError {
+		return err
 	}
+	d.Logger.Warn("Check failed, continuing", zap.String("dir", dir), zap.String("workspace", workspace), zap.Error(err))
 	return nil
 }
 
-func (d *Drifter) shouldSkipDirectory(dir string) bool {
-	if len(d.DirectoryWhitelist) == 0 {
-		return false
+// checkErrorBudget summarizes every failure recorded during the run and decides if the run as a whole failed
+func (d *Drifter) checkErrorBudget(report *Report) error {
+	failures := report.failures()
+	if len(failures) == 0 {
+		return nil
 	}
-	for _, whitelist := range d.DirectoryWhitelist {
-		if dir == whitelist {
-			return false
-		}
+	errs := make([]error, 0, len(failures))
+	for _, f := range failures {
+		d.Logger.Error("Failed check", zap.String("dir", f.Dir), zap.String("workspace", f.Workspace), zap.String("error", f.Error))
+		errs = append(errs, errors.New(f.String()))
+	}
+	if d.ErrorBudget < 0 || len(failures) <= d.ErrorBudget {
+		d.Logger.Warn("Some checks failed, but within the error budget", zap.Int("failures", len(failures)), zap.Int("error-budget", d.ErrorBudget))
+		return nil
+	}
+	return fmt.Errorf("%d checks failed, exceeding error budget of %d: %w", len(failures), d.ErrorBudget, errors.Join(errs...))
+}
+
+// shouldSkipDirectory returns true if dir should not be checked, along with the reason why
+func (d *Drifter) shouldSkipDirectory(dir string) (bool, string) {
+	if len(d.DirectoryWhitelist) != 0 && !contains(d.DirectoryWhitelist, dir) {
+		return true, "not in directory whitelist"
+	}
+	allows, reason := d.DirectoryFilter.Allows(dir)
+	return !allows, reason
+}
+
+// shouldSkipWorkspace returns true if workspace should not be checked, along with the reason why
+func (d *Drifter) shouldSkipWorkspace(workspace string) (bool, string) {
+	allows, reason := d.WorkspaceFilter.Allows(workspace)
+	return !allows, reason
+}
+
+// shouldSkipProject returns true if the atlantis project should not be checked, along with the reason why
+func (d *Drifter) shouldSkipProject(projectName string) (bool, string) {
+	allows, reason := d.ProjectFilter.Allows(projectName)
+	if reason != "" {
+		reason = "project " + reason
+	}
+	return !allows, reason
+}
+
+func location(dir string, workspace atlantis.Workspace) notification.Location {
+	return notification.Location{
+		Directory:   dir,
+		Workspace:   workspace.Name,
+		ProjectName: workspace.ProjectName,
 	}
-	return true
+}
+
+// planChanges returns what a plan would change, in the form notifications and the report use
+func planChanges(pr *atlantis.PlanResult) notification.PlanChanges {
+	stats := pr.Stats()
+	ret := notification.PlanChanges{
+		Import:         stats.Import,
+		Add:            stats.Add,
+		Change:         stats.Change,
+		Destroy:        stats.Destroy,
+		ChangesOutside: stats.ChangesOutside,
+	}
+	for _, r := range pr.Resources() {
+		ret.Resources = append(ret.Resources, notification.ResourceChange{
+			Address: r.Address,
+			Action:  string(r.Action),
+		})
+	}
+	return ret
 }
 
 type errFunc func(ctx context.Context) error
@@ -113,139 +314,535 @@ func (d *Drifter) drainAndExecute(ctx context.Context, toRun []errFunc) error {
 	return eg.Wait()
 }
 
-func (d *Drifter) FindDriftedWorkspaces(ctx context.Context, ws atlantis.DirectoriesWithWorkspaces) error {
+func (d *Drifter) FindDriftedWorkspaces(ctx context.Context, ws atlantis.DirectoriesWithWorkspaces, report *Report) error {
+	checks, err := d.orderWorkspaces(ctx, ws)
+	if err != nil {
+		return fmt.Errorf("failed to order workspaces: %w", err)
+	}
+	if d.ParallelUnit == ParallelWorkspace {
+		return d.findDriftedByWorkspace(ctx, checks, report)
+	}
+	dirs, grouped := groupByDirectory(checks)
 	runningFunc := func(dir string) errFunc {
 		return func(ctx context.Context) error {
-			if d.shouldSkipDirectory(dir) {
-				d.Logger.Info("Skipping directory", zap.String("dir", dir))
+			dirChecks := grouped[dir]
+			if skip, reason := d.shouldSkipDirectory(dir); skip {
+				d.Logger.Info("Skipping directory", zap.String("dir", dir), zap.String("reason", reason))
+				for _, c := range dirChecks {
+					d.reportUncheckedWorkspace(report, dir, c.workspace, OutcomeSkippedFilter, reason)
+				}
 				return nil
 			}
-			workspaces := ws[dir]
 			d.Logger.Info("Checking for drifted workspaces", zap.String("dir", dir))
-			for _, workspace := range workspaces {
-				cacheKey := &processedcache.ConsiderDriftChecked{
-					Dir:       dir,
-					Workspace: workspace,
-				}
-				cacheVal, err := d.ResultCache.GetDriftCheckResult(ctx, cacheKey)
-				if err != nil {
-					return fmt.Errorf("failed to get cache value for %s/%s: %w", dir, workspace, err)
-				}
-				if cacheVal != nil {
-					if time.Since(cacheVal.When) < d.CacheValidDuration {
-						d.Logger.Info("Skipping workspace, already checked", zap.String("dir", dir), zap.String("workspace", workspace))
-						continue
-					}
-					d.Logger.Info("Cache expired, checking again", zap.String("dir", dir), zap.String("workspace", workspace), zap.Duration("cache-age", time.Since(cacheVal.When)), zap.Duration("cache-valid-duration", d.CacheValidDuration))
-					if err := d.ResultCache.DeleteDriftCheckResult(ctx, cacheKey); err != nil {
-						return fmt.Errorf("failed to delete cache value for %s/%s: %w", dir, workspace, err)
-					}
-				}
-
-				pr, err := d.AtlantisClient.PlanSummary(ctx, &atlantis.PlanSummaryRequest{
-					Repo:      d.Repo,
-					Ref:       "master",
-					Type:      "Github",
-					Dir:       dir,
-					Workspace: workspace,
-				})
-				if err != nil {
-					var tmp atlantis.TemporaryError
-					if errors.As(err, &tmp) && tmp.Temporary() {
-						d.Logger.Warn("Temporary error.  Will try again later.", zap.Error(err))
-						continue
-					}
-					return fmt.Errorf("failed to get plan summary for (%s#%s): %w", dir, workspace, err)
-				}
-				if err := d.ResultCache.StoreDriftCheckResult(ctx, cacheKey, &processedcache.DriftCheckValue{
-					When:  time.Now(),
-					Error: "",
-					Drift: pr.HasChanges(),
-				}); err != nil {
-					return fmt.Errorf("failed to store cache value for %s/%s: %w", dir, workspace, err)
-				}
-				if pr.IsLocked() {
-					d.Logger.Info("Plan is locked, skipping drift check", zap.String("dir", dir))
-					continue
-				}
-				if pr.HasChanges() {
-					if err := d.Notification.PlanDrift(ctx, dir, workspace); err != nil {
-						return fmt.Errorf("failed to notify of plan drift in %s: %w", dir, err)
-					}
+			for _, c := range dirChecks {
+				if err := d.findDriftedWorkspace(ctx, c, report); err != nil {
+					return err
 				}
 			}
 			return nil
 		}
 	}
 	runs := make([]errFunc, 0)
-	for _, dir := range ws.SortedKeys() {
+	for _, dir := range dirs {
 		runs = append(runs, runningFunc(dir))
 	}
 	return d.drainAndExecute(ctx, runs)
 }
 
-func (d *Drifter) FindExtraWorkspaces(ctx context.Context, ws atlantis.DirectoriesWithWorkspaces) error {
+// findDriftedByWorkspace schedules every directory/workspace pair as its own unit of work
+func (d *Drifter) findDriftedByWorkspace(ctx context.Context, checks []workspaceCheck, report *Report) error {
+	skipReasons := make(map[string]string)
+	runs := make([]workspaceCheck, 0, len(checks))
+	for _, c := range checks {
+		reason, known := skipReasons[c.dir]
+		if !known {
+			var skip bool
+			if skip, reason = d.shouldSkipDirectory(c.dir); skip {
+				d.Logger.Info("Skipping directory", zap.String("dir", c.dir), zap.String("reason", reason))
+			} else {
+				reason = ""
+			}
+			skipReasons[c.dir] = reason
+		}
+		if reason != "" {
+			d.reportUncheckedWorkspace(report, c.dir, c.workspace, OutcomeSkippedFilter, reason)
+			continue
+		}
+		runs = append(runs, c)
+	}
+	return d.drainByDirectory(ctx, runs, func(ctx context.Context, c workspaceCheck) error {
+		return d.findDriftedWorkspace(ctx, c, report)
+	})
+}
+
+// findDriftedWorkspace checks one workspace this shard owns, of a directory that passed the directory filters, adding
+// the result to report
+func (d *Drifter) findDriftedWorkspace(ctx context.Context, c workspaceCheck, report *Report) error {
+	dir, workspace := c.dir, c.workspace
+	if skip, reason := d.shouldSkipWorkspace(workspace.Name); skip {
+		d.Logger.Info("Skipping workspace", zap.String("dir", dir), zap.String("workspace", workspace.Name), zap.String("reason", reason))
+		d.reportUncheckedWorkspace(report, dir, workspace, OutcomeSkippedFilter, reason)
+		return nil
+	}
+	if skip, reason := d.shouldSkipProject(workspace.ProjectName); skip {
+		d.Logger.Info("Skipping project", zap.String("dir", dir), zap.String("workspace", workspace.Name), zap.String("project", workspace.ProjectName), zap.String("reason", reason))
+		d.reportUncheckedWorkspace(report, dir, workspace, OutcomeSkippedFilter, reason)
+		return nil
+	}
+	if reason := report.stopReason(); reason != "" {
+		d.Logger.Info("Not checking workspace", zap.String("dir", dir), zap.String("workspace", workspace.Name), zap.String("reason", reason))
+		d.reportUncheckedWorkspace(report, dir, workspace, OutcomeNotChecked, reason)
+		return nil
+	}
+	res := &WorkspaceResult{
+		Dir:         dir,
+		Workspace:   workspace.Name,
+		ProjectName: workspace.ProjectName,
+		Start:       time.Now(),
+	}
+	spanCtx, span := tracing.Tracer(tracerName).Start(ctx, "CheckWorkspace", trace.WithAttributes(tracing.DirKey.String(dir), tracing.WorkspaceKey.String(workspace.Name)))
+	checkCtx, cancel := d.withWorkspaceTimeout(spanCtx)
+	err := d.checkWorkspace(checkCtx, c, res)
+	if err != nil && timedOut(spanCtx, checkCtx) {
+		res.Outcome = OutcomeTimeout
+		err = fmt.Errorf("check of %s#%s timed out after %s: %w", dir, workspace.Name, d.WorkspaceTimeout, err)
+	}
+	cancel()
+	if checkErr := checkFailure(res, err); checkErr != nil {
+		if recordErr := d.recordDriftCheckFailure(spanCtx, dir, workspace, checkErr); recordErr != nil {
+			err = errors.Join(err, recordErr)
+		}
+	}
+	span.SetAttributes(attribute.String("drift.outcome", string(res.Outcome)))
+	tracing.RecordError(span, err)
+	span.End()
+	res.End = time.Now()
+	if err != nil {
+		if res.Outcome == "" {
+			res.Outcome = OutcomeError
+		}
+		res.Error = err.Error()
+	}
+	report.addWorkspace(res)
+	d.recordDriftState(res)
+	if err != nil {
+		return d.recordFailure(report, dir, workspace.Name, err)
+	}
+	return nil
+}
+
+// recordDriftState updates the drift state metric for a workspace that was checked
+func (d *Drifter) recordDriftState(res *WorkspaceResult) {
+	switch res.Outcome {
+	case OutcomeDrift:
+		d.Metrics.SetDriftState(res.Dir, res.Workspace, metrics.StateDrifted)
+	case OutcomeNoDrift:
+		d.Metrics.SetDriftState(res.Dir, res.Workspace, metrics.StateClean)
+	case OutcomeLocked:
+		d.Metrics.SetDriftState(res.Dir, res.Workspace, metrics.StateLocked)
+	case OutcomeError, OutcomeTemporaryError, OutcomeTimeout:
+		d.Metrics.SetDriftState(res.Dir, res.Workspace, metrics.StateError)
+	}
+}
+
+// reportNotScheduled reports every check of ws this shard owns that is not in report yet as not checked, for reason
+func (d *Drifter) reportNotScheduled(ws atlantis.DirectoriesWithWorkspaces, report *Report, reason string) {
+	workspaces, dirs := report.reported()
+	for _, dir := range ws.SortedKeys() {
+		for _, w := range ws[dir] {
+			if d.Shard.Owns(dir, w.Name) && !workspaces[reportedWorkspace{dir: dir, workspace: w.Name, project: w.ProjectName}] {
+				d.reportUncheckedWorkspace(report, dir, w, OutcomeNotChecked, reason)
+			}
+		}
+		if !d.SkipWorkspaceCheck && d.Shard.Owns(dir, "") && !dirs[dir] {
+			now := time.Now()
+			report.addDirectory(&DirectoryResult{
+				Dir:     dir,
+				Outcome: OutcomeNotChecked,
+				Start:   now,
+				End:     now,
+				Reason:  reason,
+			})
+		}
+	}
+}
+
+func (d *Drifter) reportUncheckedWorkspace(report *Report, dir string, workspace atlantis.Workspace, outcome Outcome, reason string) {
+	now := time.Now()
+	report.addWorkspace(&WorkspaceResult{
+		Dir:         dir,
+		Workspace:   workspace.Name,
+		ProjectName: workspace.ProjectName,
+		Outcome:     outcome,
+		Start:       now,
+		End:         now,
+		Reason:      reason,
+	})
+}
+
+// checkWorkspace checks a single directory/workspace for drift, filling in the outcome of res
+func (d *Drifter) checkWorkspace(ctx context.Context, c workspaceCheck, res *WorkspaceResult) error {
+	dir, ws := c.dir, c.workspace
+	workspace := ws.Name
+	cacheKey := &processedcache.ConsiderDriftChecked{
+		Dir:       dir,
+		Workspace: workspace,
+	}
+	cacheVal := c.cached
+	if !c.cacheRead {
+		var err error
+		cacheVal, err = d.ResultCache.GetDriftCheckResult(ctx, cacheKey)
+		if err != nil {
+			return fmt.Errorf("failed to get cache value for %s/%s: %w", dir, workspace, err)
+		}
+	}
+	if cacheVal != nil && cacheVal.Error != "" {
+		if backoff, reason := d.inFailureBackoff(cacheVal.FailureCount, cacheVal.When); backoff {
+			d.Metrics.CacheLookup(metrics.CheckDrift, true)
+			d.Logger.Info("Skipping workspace, backing off after failures", zap.String("dir", dir), zap.String("workspace", workspace), zap.String("reason", reason))
+			res.Outcome = OutcomeSkippedBackoff
+			res.Reason = reason
+			return nil
+		}
+	} else if cacheVal != nil && time.Since(cacheVal.When) < d.CacheValidDuration {
+		d.Metrics.CacheLookup(metrics.CheckDrift, true)
+		d.Logger.Info("Skipping workspace, already checked", zap.String("dir", dir), zap.String("workspace", workspace))
+		res.Outcome = OutcomeSkippedCache
+		res.Reason = cacheReason(cacheVal.When, d.CacheValidDuration)
+		return nil
+	}
+	d.Metrics.CacheLookup(metrics.CheckDrift, false)
+	if d.DryRun {
+		res.Outcome = OutcomeWouldPlan
+		res.Reason = expiredReason(cacheVal != nil)
+		return nil
+	}
+	// The expired value is kept, rather than deleted, so that we only notify when the drift state changes
+	wasDrifted := false
+	var lastNotified time.Time
+	if cacheVal != nil {
+		d.Logger.Info("Cache expired, checking again", zap.String("dir", dir), zap.String("workspace", workspace), zap.Duration("cache-age", time.Since(cacheVal.When)), zap.Duration("cache-valid-duration", d.CacheValidDuration))
+		wasDrifted = cacheVal.Drift
+		lastNotified = cacheVal.LastNotified
+	}
+
+	pr, err := d.planSummary(ctx, &atlantis.PlanSummaryRequest{
+		Repo:      d.Repo,
+		Ref:       "master",
+		Type:      "Github",
+		Dir:       dir,
+		Workspace: workspace,
+	})
+	if err != nil {
+		if isTemporary(err) {
+			d.Logger.Warn("Temporary error.  Will try again later.", zap.Error(err))
+			res.Outcome = OutcomeTemporaryError
+			res.Error = err.Error()
+			if err := d.Notification.TemporaryError(ctx, location(dir, ws), err); err != nil {
+				return fmt.Errorf("failed to notify of temporary error in %s: %w", dir, err)
+			}
+			return nil
+		}
+		return fmt.Errorf("failed to get plan summary for (%s#%s): %w", dir, workspace, err)
+	}
+	for _, s := range pr.Summaries {
+		res.PlanSummaries = append(res.PlanSummaries, s.Summary)
+	}
+	drifted := pr.HasChanges()
+	if pr.IsLocked() {
+		// A locked plan tells us nothing new, so remember whatever we knew before
+		drifted = wasDrifted
+	} else {
+		changes := planChanges(pr)
+		if drifted {
+			if !resourcesAddUp(changes) {
+				d.Logger.Warn("Resource changes found in the plan do not add up to its totals", zap.String("dir", dir), zap.String("workspace", workspace), zap.Stringer("totals", changes), zap.Int("resources", len(changes.Resources)))
+			}
+			changes, drifted = d.applyIgnoreRules(dir, workspace, changes, res)
+		}
+		res.Changes = &changes
+	}
+	var notified time.Time
+	if drifted {
+		notified = lastNotified
+	}
+	var lockedSince time.Time
+	var lockPullID int
+	switch {
+	case pr.IsLocked():
+		lock := d.lockHolder(ctx, dir, workspace, pr.Lock(), cacheVal)
+		d.Logger.Info("Plan is locked, skipping drift check", zap.String("dir", dir), zap.String("workspace", workspace), zap.Int("pull", lock.PullID), zap.Time("locked-since", lock.Since))
+		res.Outcome = OutcomeLocked
+		res.Lock = &lock
+		res.Reason = "locked by " + lock.String()
+		lockedSince = lock.Since
+		lockPullID = lock.PullID
+		if d.lockBecameStale(lock, cacheVal) {
+			if err := d.Notification.StaleLock(ctx, location(dir, ws), lock); err != nil {
+				return fmt.Errorf("failed to notify of stale lock in %s: %w", dir, err)
+			}
+			res.Notified = true
+		}
+	case drifted:
+		res.Outcome = OutcomeDrift
+		ack, err := d.acknowledgment(ctx, dir, workspace)
+		if err != nil {
+			return err
+		}
+		if ack != nil {
+			d.Logger.Info("Drift acknowledged, not notifying", zap.String("dir", dir), zap.String("workspace", workspace), zap.String("acknowledgment", ack.String()))
+			res.Snoozed = true
+			res.Reason = ack.String()
+			// Forgetting the last notification makes the drift new again once the acknowledgment expires
+			notified = time.Time{}
+			break
+		}
+		if !d.shouldNotifyDrift(wasDrifted, lastNotified) {
+			d.Logger.Info("Drift already reported", zap.String("dir", dir), zap.String("workspace", workspace), zap.Time("last-notified", lastNotified))
+			break
+		}
+		if err := d.Notification.PlanDrift(ctx, location(dir, ws), *res.Changes); err != nil {
+			return fmt.Errorf("failed to notify of plan drift in %s: %w", dir, err)
+		}
+		res.Notified = true
+		notified = time.Now()
+	default:
+		res.Outcome = OutcomeNoDrift
+		// Drift that was never notified, for example because it was acknowledged the whole time, has nothing to resolve
+		if wasDrifted && !lastNotified.IsZero() {
+			if err := d.Notification.DriftResolved(ctx, location(dir, ws)); err != nil {
+				return fmt.Errorf("failed to notify of resolved drift in %s: %w", dir, err)
+			}
+			res.Notified = true
+		}
+	}
+	// Stored after notifying, so that a failed notification is tried again on the next check
+	if err := d.ResultCache.StoreDriftCheckResult(ctx, cacheKey, &processedcache.DriftCheckValue{
+		When:         time.Now(),
+		Error:        "",
+		Drift:        drifted,
+		LastNotified: notified,
+		LockedSince:  lockedSince,
+		LockPullID:   lockPullID,
+	}); err != nil {
+		return fmt.Errorf("failed to store cache value for %s/%s: %w", dir, workspace, err)
+	}
+	return nil
+}
+
+// shouldNotifyDrift returns true if drift found now should be notified, given what we knew before the check
+func (d *Drifter) shouldNotifyDrift(wasDrifted bool, lastNotified time.Time) bool {
+	// Drift that was never notified, for example because it was acknowledged until now, is new to whoever is notified
+	if !wasDrifted || lastNotified.IsZero() {
+		return true
+	}
+	return d.DriftReminderInterval > 0 && time.Since(lastNotified) >= d.DriftReminderInterval
+}
+
+func (d *Drifter) FindExtraWorkspaces(ctx context.Context, ws atlantis.DirectoriesWithWorkspaces, report *Report) error {
 	if d.SkipWorkspaceCheck {
 		return nil
 	}
 	runFunc := func(dir string) errFunc {
 		return func(ctx context.Context) error {
-			if d.shouldSkipDirectory(dir) {
-				d.Logger.Info("Skipping directory", zap.String("dir", dir))
+			res := &DirectoryResult{
+				Dir:   dir,
+				Start: time.Now(),
+			}
+			if reason := report.stopReason(); reason != "" {
+				d.Logger.Info("Not checking remote workspaces", zap.String("dir", dir), zap.String("reason", reason))
+				res.Outcome = OutcomeNotChecked
+				res.Reason = reason
+				res.End = res.Start
+				report.addDirectory(res)
 				return nil
 			}
-			cacheKey := &processedcache.ConsiderWorkspacesChecked{
-				Dir: dir,
+			spanCtx, span := tracing.Tracer(tracerName).Start(ctx, "CheckRemoteWorkspaces", trace.WithAttributes(tracing.DirKey.String(dir)))
+			checkCtx, cancel := d.withWorkspaceTimeout(spanCtx)
+			err := d.checkRemoteWorkspaces(checkCtx, dir, ws[dir], res)
+			if err != nil && timedOut(spanCtx, checkCtx) {
+				res.Outcome = OutcomeTimeout
+				err = fmt.Errorf("check of remote workspaces in %s timed out after %s: %w", dir, d.WorkspaceTimeout, err)
 			}
-			cacheVal, err := d.ResultCache.GetRemoteWorkspaces(ctx, cacheKey)
+			cancel()
 			if err != nil {
-				return fmt.Errorf("failed to get cache value for %s: %w", dir, err)
-			}
-			if cacheVal != nil {
-				if time.Since(cacheVal.When) < d.CacheValidDuration {
-					d.Logger.Info("Skipping directory, in cache", zap.String("dir", dir))
-					return nil
-				}
-				d.Logger.Info("Cache expired, checking again", zap.String("dir", dir), zap.Duration("cache-age", time.Since(cacheVal.When)), zap.Duration("cache-valid-duration", d.CacheValidDuration))
-				if err := d.ResultCache.DeleteRemoteWorkspaces(ctx, cacheKey); err != nil {
-					return fmt.Errorf("failed to delete cache value for %s: %w", dir, err)
+				if recordErr := d.recordRemoteCheckFailure(spanCtx, dir, err); recordErr != nil {
+					err = errors.Join(err, recordErr)
 				}
 			}
-			workspaces := ws[dir]
-			d.Logger.Info("Checking for extra workspaces", zap.String("dir", dir))
-			if err := d.Terraform.Init(ctx, dir); err != nil {
-				return fmt.Errorf("failed to init workspace %s: %w", dir, err)
-			}
-			var expectedWorkspaces []string
-			expectedWorkspaces = append(expectedWorkspaces, workspaces...)
-			expectedWorkspaces = append(expectedWorkspaces, "default")
-			remoteWorkspaces, err := d.Terraform.ListWorkspaces(ctx, dir)
+			span.SetAttributes(attribute.String("drift.outcome", string(res.Outcome)))
+			tracing.RecordError(span, err)
+			span.End()
+			res.End = time.Now()
 			if err != nil {
-				return fmt.Errorf("failed to list workspaces in %s: %w", dir, err)
-			}
-			for _, w := range remoteWorkspaces {
-				if !contains(expectedWorkspaces, w) {
-					if err := d.Notification.ExtraWorkspaceInRemote(ctx, dir, w); err != nil {
-						return fmt.Errorf("failed to notify of extra workspace %s in %s: %w", w, dir, err)
-					}
+				if res.Outcome == "" {
+					res.Outcome = OutcomeError
 				}
+				res.Error = err.Error()
 			}
-			if err := d.ResultCache.StoreRemoteWorkspaces(ctx, cacheKey, &processedcache.WorkspacesCheckedValue{
-				Workspaces: remoteWorkspaces,
-				When:       time.Now(),
-			}); err != nil {
-				return fmt.Errorf("failed to store cache value for %s: %w", dir, err)
+			report.addDirectory(res)
+			if err != nil {
+				return d.recordFailure(report, dir, "", err)
 			}
 			return nil
 		}
 	}
-	runs := make([]errFunc, 0)
+	owned := make([]string, 0, len(ws))
 	for _, dir := range ws.SortedKeys() {
+		if d.Shard.Owns(dir, "") {
+			owned = append(owned, dir)
+		}
+	}
+	dirs, err := d.orderDirectories(ctx, owned)
+	if err != nil {
+		return fmt.Errorf("failed to order directories: %w", err)
+	}
+	runs := make([]errFunc, 0)
+	for _, dir := range dirs {
 		runs = append(runs, runFunc(dir))
 	}
 	return d.drainAndExecute(ctx, runs)
 }
 
+// checkRemoteWorkspaces compares the workspaces in a directory's remote backend with what atlantis expects
+func (d *Drifter) checkRemoteWorkspaces(ctx context.Context, dir string, workspaces []atlantis.Workspace, res *DirectoryResult) error {
+	if skip, reason := d.shouldSkipDirectory(dir); skip {
+		d.Logger.Info("Skipping directory", zap.String("dir", dir), zap.String("reason", reason))
+		res.Outcome = OutcomeSkippedFilter
+		res.Reason = reason
+		return nil
+	}
+	cacheKey := &processedcache.ConsiderWorkspacesChecked{
+		Dir: dir,
+	}
+	cacheVal, err := d.ResultCache.GetRemoteWorkspaces(ctx, cacheKey)
+	if err != nil {
+		return fmt.Errorf("failed to get cache value for %s: %w", dir, err)
+	}
+	if cacheVal != nil && cacheVal.Error != "" {
+		if backoff, reason := d.inFailureBackoff(cacheVal.FailureCount, cacheVal.When); backoff {
+			d.Metrics.CacheLookup(metrics.CheckWorkspaces, true)
+			d.Logger.Info("Skipping directory, backing off after failures", zap.String("dir", dir), zap.String("reason", reason))
+			res.Outcome = OutcomeSkippedBackoff
+			res.Reason = reason
+			return nil
+		}
+	} else if cacheVal != nil && time.Since(cacheVal.When) < d.CacheValidDuration {
+		d.Metrics.CacheLookup(metrics.CheckWorkspaces, true)
+		d.Logger.Info("Skipping directory, in cache", zap.String("dir", dir))
+		res.Outcome = OutcomeSkippedCache
+		res.Reason = cacheReason(cacheVal.When, d.CacheValidDuration)
+		return nil
+	}
+	d.Metrics.CacheLookup(metrics.CheckWorkspaces, false)
+	if d.DryRun {
+		res.Outcome = OutcomeWouldCheck
+		res.Reason = expiredReason(cacheVal != nil)
+		return nil
+	}
+	// The expired value is kept, rather than deleted, so that a failure can count how many checks in a row failed
+	if cacheVal != nil {
+		d.Logger.Info("Cache expired, checking again", zap.String("dir", dir), zap.Duration("cache-age", time.Since(cacheVal.When)), zap.Duration("cache-valid-duration", d.CacheValidDuration))
+	}
+	d.Logger.Info("Checking for extra workspaces", zap.String("dir", dir))
+	initStart := time.Now()
+	err = d.Terraform.Init(ctx, dir)
+	d.Metrics.ObserveInit(time.Since(initStart))
+	if err != nil {
+		return fmt.Errorf("failed to init workspace %s: %w", dir, err)
+	}
+	var expectedWorkspaces []string
+	for _, w := range workspaces {
+		expectedWorkspaces = append(expectedWorkspaces, atlantis.RemoteWorkspaceName(w.Name))
+	}
+	if !d.CheckUnusedDefaultWorkspace {
+		expectedWorkspaces = append(expectedWorkspaces, "default")
+	}
+	remoteWorkspaces, err := d.Terraform.ListWorkspaces(ctx, dir)
+	if err != nil {
+		return fmt.Errorf("failed to list workspaces in %s: %w", dir, err)
+	}
+	for _, w := range remoteWorkspaces {
+		if skip, reason := d.shouldSkipWorkspace(w); skip {
+			d.Logger.Info("Ignoring remote workspace", zap.String("dir", dir), zap.String("workspace", w), zap.String("reason", reason))
+			continue
+		}
+		if ignored, reason := d.ignoreRemoteWorkspace(dir, w); ignored {
+			d.Logger.Info("Ignoring remote workspace", zap.String("dir", dir), zap.String("workspace", w), zap.String("reason", reason))
+			continue
+		}
+		if !contains(expectedWorkspaces, w) && w == "default" {
+			// terraform always lists default, so it is only extra if something was actually applied to it
+			resources, err := d.Terraform.CountStateResources(ctx, dir, w)
+			if err != nil {
+				return fmt.Errorf("failed to count resources of default workspace in %s: %w", dir, err)
+			}
+			if resources == 0 {
+				continue
+			}
+			d.Logger.Info("Unused default workspace holds state", zap.String("dir", dir), zap.Int("resources", resources))
+		}
+		if !contains(expectedWorkspaces, w) {
+			res.ExtraWorkspaces = append(res.ExtraWorkspaces, w)
+			res.Outcome = OutcomeExtraWorkspaces
+			if err := d.Notification.ExtraWorkspaceInRemote(ctx, notification.Location{Directory: dir, Workspace: w}); err != nil {
+				return fmt.Errorf("failed to notify of extra workspace %s in %s: %w", w, dir, err)
+			}
+		}
+	}
+	for _, w := range workspaces {
+		name := atlantis.RemoteWorkspaceName(w.Name)
+		if contains(remoteWorkspaces, name) || contains(res.MissingWorkspaces, name) {
+			continue
+		}
+		if skip, _ := d.shouldSkipWorkspace(name); skip {
+			continue
+		}
+		if skip, _ := d.shouldSkipProject(w.ProjectName); skip {
+			continue
+		}
+		d.Logger.Info("Workspace missing in remote", zap.String("dir", dir), zap.String("workspace", name))
+		res.MissingWorkspaces = append(res.MissingWorkspaces, name)
+		if res.Outcome == "" {
+			res.Outcome = OutcomeMissingWorkspaces
+		}
+		if err := d.Notification.MissingWorkspaceInRemote(ctx, location(dir, atlantis.Workspace{Name: name, ProjectName: w.ProjectName})); err != nil {
+			return fmt.Errorf("failed to notify of missing workspace %s in %s: %w", name, dir, err)
+		}
+	}
+	if err := d.ResultCache.StoreRemoteWorkspaces(ctx, cacheKey, &processedcache.WorkspacesCheckedValue{
+		Workspaces: remoteWorkspaces,
+		When:       time.Now(),
+	}); err != nil {
+		return fmt.Errorf("failed to store cache value for %s: %w", dir, err)
+	}
+	if res.Outcome == "" {
+		res.Outcome = OutcomeNoDrift
+	}
+	return nil
+}
+
+func cacheReason(when time.Time, validFor time.Duration) string {
+	return fmt.Sprintf("checked %s ago, cache valid for %s", time.Since(when).Round(time.Second), validFor)
+}
+
+func expiredReason(inCache bool) string {
+	if inCache {
+		return "cache expired"
+	}
+	return "not in cache"
+}
+
+// ignoreRemoteWorkspace returns true if workspace is never reported as extra in dir, along with the reason why
+func (d *Drifter) ignoreRemoteWorkspace(dir string, workspace string) (bool, string) {
+	for _, r := range d.IgnoredRemoteWorkspaces {
+		if r.Matches(dir, workspace) {
+			return true, "ignored by " + r.Pattern
+		}
+	}
+	return false, ""
+}
+
 func contains(workspaces []string, w string) bool {
 	for _, workspace := range workspaces {
 		if workspace == w {
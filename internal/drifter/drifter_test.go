@@ -0,0 +1,422 @@
+package drifter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/cresta/atlantis-drift-detection/internal/filter"
+	"github.com/cresta/atlantis-drift-detection/internal/notification"
+	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
+	"github.com/cresta/atlantis-drift-detection/internal/terraform"
+	"github.com/cresta/atlantis-drift-detection/internal/testhelper"
+	"github.com/cresta/atlantis-drift-detection/internal/tracing"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/zap/zaptest"
+)
+
+const (
+	noChangesResult = `{"ProjectResults":[{"PlanSuccess":{"TerraformOutput":"No changes. Your infrastructure matches the configuration."}}]}`
+	changesResult   = `{"ProjectResults":[{"PlanSuccess":{"TerraformOutput":"  # aws_instance.web will be created\n\nPlan: 1 to add, 0 to change, 0 to destroy."}}]}`
+)
+
+// fakeAtlantis is an atlantis server that answers every plan request with its current body, and lock requests with
+// its current locks
+type fakeAtlantis struct {
+	mu           sync.Mutex
+	body         string
+	locks        string
+	lockRequests int
+}
+
+func (f *fakeAtlantis) setBody(body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.body = body
+}
+
+func (f *fakeAtlantis) countLockRequests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lockRequests
+}
+
+func (f *fakeAtlantis) client(t *testing.T) *atlantis.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		body := f.body
+		if r.URL.Path == "/api/locks" {
+			f.lockRequests++
+			body = f.locks
+		}
+		_, err := w.Write([]byte(body))
+		require.NoError(t, err)
+	}))
+	t.Cleanup(srv.Close)
+	return &atlantis.Client{
+		AtlantisHostname: srv.URL,
+		HTTPClient:       srv.Client(),
+	}
+}
+
+// recordingNotification remembers the name of every notification sent to it
+type recordingNotification struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recordingNotification) record(event string, loc notification.Location) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event+" "+loc.Directory+"#"+loc.Workspace)
+	return nil
+}
+
+// take returns every notification since the last call
+func (r *recordingNotification) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ret := r.events
+	r.events = nil
+	return ret
+}
+
+func (r *recordingNotification) ExtraWorkspaceInRemote(_ context.Context, loc notification.Location) error {
+	return r.record("ExtraWorkspaceInRemote", loc)
+}
+
+func (r *recordingNotification) MissingWorkspaceInRemote(_ context.Context, loc notification.Location) error {
+	return r.record("MissingWorkspaceInRemote", loc)
+}
+
+func (r *recordingNotification) PlanDrift(_ context.Context, loc notification.Location, _ notification.PlanChanges) error {
+	return r.record("PlanDrift", loc)
+}
+
+func (r *recordingNotification) DriftResolved(_ context.Context, loc notification.Location) error {
+	return r.record("DriftResolved", loc)
+}
+
+func (r *recordingNotification) TemporaryError(_ context.Context, loc notification.Location, _ error) error {
+	return r.record("TemporaryError", loc)
+}
+
+func (r *recordingNotification) StaleLock(_ context.Context, loc notification.Location, _ notification.Lock) error {
+	return r.record("StaleLock", loc)
+}
+
+func (r *recordingNotification) PersistentFailure(_ context.Context, loc notification.Location, _ int, _ error) error {
+	return r.record("PersistentFailure", loc)
+}
+
+var _ notification.Notification = &recordingNotification{}
+
+// testDrifter returns a Drifter whose cache always considers the last check expired
+func testDrifter(t *testing.T, fake *fakeAtlantis, notif notification.Notification) *Drifter {
+	return &Drifter{
+		Logger:         zaptest.NewLogger(t),
+		Repo:           "cresta/terraform",
+		AtlantisClient: fake.client(t),
+		ResultCache:    &processedcache.Memory{},
+		Notification:   notif,
+	}
+}
+
+func TestDrifter_checkErrorBudget(t *testing.T) {
+	d := Drifter{
+		Logger:          zaptest.NewLogger(t),
+		ContinueOnError: true,
+		ErrorBudget:     1,
+	}
+	var report Report
+	require.NoError(t, d.checkErrorBudget(&report))
+	require.NoError(t, d.recordFailure(&report, "dir1", "ws1", errors.New("bad plan")))
+	require.NoError(t, d.checkErrorBudget(&report))
+	require.NoError(t, d.recordFailure(&report, "dir2", "", errors.New("bad init")))
+	err := d.checkErrorBudget(&report)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "dir1#ws1: bad plan")
+	require.Contains(t, err.Error(), "dir2: bad init")
+	d.ErrorBudget = -1
+	require.NoError(t, d.checkErrorBudget(&report))
+}
+
+func TestDrifter_recordFailureStops(t *testing.T) {
+	d := Drifter{
+		Logger: zaptest.NewLogger(t),
+	}
+	var report Report
+	require.Error(t, d.recordFailure(&report, "dir1", "ws1", errors.New("bad plan")))
+	require.Len(t, report.Failures, 1)
+}
+
+func TestDrifter_ignoreRemoteWorkspace(t *testing.T) {
+	rules, err := filter.NewLocationRules([]string{"scratch-*", "environments/aws/**#default"})
+	require.NoError(t, err)
+	d := Drifter{
+		IgnoredRemoteWorkspaces: rules,
+	}
+	ignored, reason := d.ignoreRemoteWorkspace("environments/gcp/a", "scratch-1")
+	require.True(t, ignored)
+	require.Equal(t, "ignored by scratch-*", reason)
+	ignored, _ = d.ignoreRemoteWorkspace("environments/aws/a", "default")
+	require.True(t, ignored)
+	ignored, _ = d.ignoreRemoteWorkspace("environments/gcp/a", "default")
+	require.False(t, ignored)
+}
+
+func TestDrifter_checkWorkspaceTransitions(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeAtlantis{}
+	notif := &recordingNotification{}
+	d := testDrifter(t, fake, notif)
+	ws := atlantis.Workspace{Name: "prod"}
+	check := func(body string) *WorkspaceResult {
+		fake.setBody(body)
+		var res WorkspaceResult
+		require.NoError(t, d.checkWorkspace(ctx, workspaceCheck{dir: "dir", workspace: ws}, &res))
+		return &res
+	}
+
+	require.Equal(t, OutcomeNoDrift, check(noChangesResult).Outcome)
+	require.Empty(t, notif.take())
+
+	res := check(changesResult)
+	require.Equal(t, OutcomeDrift, res.Outcome)
+	require.Equal(t, &notification.PlanChanges{
+		Add:       1,
+		Resources: []notification.ResourceChange{{Address: "aws_instance.web", Action: "create"}},
+	}, res.Changes)
+	require.True(t, res.Notified)
+	require.Equal(t, []string{"PlanDrift dir#prod"}, notif.take())
+
+	res = check(changesResult)
+	require.Equal(t, OutcomeDrift, res.Outcome)
+	require.False(t, res.Notified)
+	require.Empty(t, notif.take())
+
+	res = check(noChangesResult)
+	require.Equal(t, OutcomeNoDrift, res.Outcome)
+	require.True(t, res.Notified)
+	require.Equal(t, []string{"DriftResolved dir#prod"}, notif.take())
+}
+
+func TestDrifter_checkWorkspaceReminder(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeAtlantis{}
+	fake.setBody(changesResult)
+	notif := &recordingNotification{}
+	d := testDrifter(t, fake, notif)
+	d.DriftReminderInterval = time.Hour
+	ws := atlantis.Workspace{Name: "prod"}
+	key := &processedcache.ConsiderDriftChecked{Dir: "dir", Workspace: "prod"}
+
+	var res WorkspaceResult
+	require.NoError(t, d.checkWorkspace(ctx, workspaceCheck{dir: "dir", workspace: ws}, &res))
+	require.Equal(t, []string{"PlanDrift dir#prod"}, notif.take())
+	val, err := d.ResultCache.GetDriftCheckResult(ctx, key)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), val.LastNotified, time.Minute)
+
+	require.NoError(t, d.checkWorkspace(ctx, workspaceCheck{dir: "dir", workspace: ws}, &res))
+	require.Empty(t, notif.take())
+
+	val.LastNotified = time.Now().Add(-2 * time.Hour)
+	require.NoError(t, d.ResultCache.StoreDriftCheckResult(ctx, key, val))
+	res = WorkspaceResult{}
+	require.NoError(t, d.checkWorkspace(ctx, workspaceCheck{dir: "dir", workspace: ws}, &res))
+	require.True(t, res.Notified)
+	require.Equal(t, []string{"PlanDrift dir#prod"}, notif.take())
+}
+
+func TestDrifter_checkWorkspaceSnoozed(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeAtlantis{}
+	fake.setBody(changesResult)
+	notif := &recordingNotification{}
+	d := testDrifter(t, fake, notif)
+	ws := atlantis.Workspace{Name: "prod"}
+	key := &processedcache.ConsiderDriftChecked{Dir: "dir", Workspace: "prod"}
+	require.NoError(t, d.ResultCache.StoreAcknowledgment(ctx, key, &processedcache.AcknowledgmentValue{
+		Reason:  "known",
+		Expires: time.Now().Add(time.Hour),
+	}))
+
+	var res WorkspaceResult
+	require.NoError(t, d.checkWorkspace(ctx, workspaceCheck{dir: "dir", workspace: ws}, &res))
+	require.Equal(t, OutcomeDrift, res.Outcome)
+	require.True(t, res.Snoozed)
+	require.Contains(t, res.Reason, "known")
+	require.Empty(t, notif.take())
+
+	// Drift that goes away while snoozed was never announced, so its resolution is not either
+	fake.setBody(noChangesResult)
+	res = WorkspaceResult{}
+	require.NoError(t, d.checkWorkspace(ctx, workspaceCheck{dir: "dir", workspace: ws}, &res))
+	require.Equal(t, OutcomeNoDrift, res.Outcome)
+	require.False(t, res.Notified)
+	require.Empty(t, notif.take())
+
+	fake.setBody(changesResult)
+	res = WorkspaceResult{}
+	require.NoError(t, d.checkWorkspace(ctx, workspaceCheck{dir: "dir", workspace: ws}, &res))
+	require.True(t, res.Snoozed)
+	require.Empty(t, notif.take())
+
+	// Once the acknowledgment is gone, the drift was never notified so it is new
+	require.NoError(t, d.ResultCache.DeleteAcknowledgment(ctx, key))
+	res = WorkspaceResult{}
+	require.NoError(t, d.checkWorkspace(ctx, workspaceCheck{dir: "dir", workspace: ws}, &res))
+	require.False(t, res.Snoozed)
+	require.Equal(t, []string{"PlanDrift dir#prod"}, notif.take())
+
+	fake.setBody(noChangesResult)
+	res = WorkspaceResult{}
+	require.NoError(t, d.checkWorkspace(ctx, workspaceCheck{dir: "dir", workspace: ws}, &res))
+	require.True(t, res.Notified)
+	require.Equal(t, []string{"DriftResolved dir#prod"}, notif.take())
+}
+
+func TestDrifter_checkWorkspaceAcknowledgmentExpires(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeAtlantis{}
+	fake.setBody(changesResult)
+	notif := &recordingNotification{}
+	d := testDrifter(t, fake, notif)
+	ws := workspaceCheck{dir: "dir", workspace: atlantis.Workspace{Name: "prod"}}
+	key := &processedcache.ConsiderDriftChecked{Dir: "dir", Workspace: "prod"}
+	check := func() *WorkspaceResult {
+		var res WorkspaceResult
+		require.NoError(t, d.checkWorkspace(ctx, ws, &res))
+		return &res
+	}
+
+	require.True(t, check().Notified)
+	require.Equal(t, []string{"PlanDrift dir#prod"}, notif.take())
+
+	require.NoError(t, d.ResultCache.StoreAcknowledgment(ctx, key, &processedcache.AcknowledgmentValue{
+		Reason:  "known",
+		Expires: time.Now().Add(time.Hour),
+	}))
+	require.True(t, check().Snoozed)
+	require.Empty(t, notif.take())
+
+	// The acknowledgment expiring brings the notifications back, even without reminders
+	require.NoError(t, d.ResultCache.StoreAcknowledgment(ctx, key, &processedcache.AcknowledgmentValue{
+		Reason:  "known",
+		Expires: time.Now().Add(-time.Minute),
+	}))
+	res := check()
+	require.False(t, res.Snoozed)
+	require.True(t, res.Notified)
+	require.Equal(t, []string{"PlanDrift dir#prod"}, notif.take())
+}
+
+func TestDrifter_spans(t *testing.T) {
+	recorder := testhelper.RecordSpans(t)
+	ctx := context.Background()
+	fake := &fakeAtlantis{}
+	fake.setBody(changesResult)
+	d := testDrifter(t, fake, &recordingNotification{})
+	var report Report
+	require.NoError(t, d.findDriftedWorkspace(ctx, workspaceCheck{dir: "dir", workspace: atlantis.Workspace{Name: "prod"}}, &report))
+	d.CacheValidDuration = time.Hour
+	require.NoError(t, d.ResultCache.StoreRemoteWorkspaces(ctx, &processedcache.ConsiderWorkspacesChecked{Dir: "dir"}, &processedcache.WorkspacesCheckedValue{When: time.Now()}))
+	require.NoError(t, d.FindExtraWorkspaces(ctx, atlantis.DirectoriesWithWorkspaces{"dir": {{Name: "prod"}}}, &report))
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	require.Contains(t, spans, "CheckWorkspace")
+	require.Subset(t, spans["CheckWorkspace"].Attributes(), []attribute.KeyValue{
+		tracing.DirKey.String("dir"),
+		tracing.WorkspaceKey.String("prod"),
+		attribute.String("drift.outcome", string(OutcomeDrift)),
+	})
+	require.Contains(t, spans, "PlanSummary")
+	require.Equal(t, spans["CheckWorkspace"].SpanContext().SpanID(), spans["PlanSummary"].Parent().SpanID())
+	require.Contains(t, spans, "CheckRemoteWorkspaces")
+	require.Subset(t, spans["CheckRemoteWorkspaces"].Attributes(), []attribute.KeyValue{
+		tracing.DirKey.String("dir"),
+		attribute.String("drift.outcome", string(OutcomeSkippedCache)),
+	})
+}
+
+// fakeTerraform returns a terraform client whose terraform binary lists remoteWorkspaces, and whose workspaces hold no
+// state
+func fakeTerraform(t *testing.T, remoteWorkspaces ...string) *terraform.Client {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake terraform is a shell script")
+	}
+	bin := t.TempDir()
+	script := "#!/bin/sh\nif [ \"$1\" = workspace ]; then\n"
+	for _, w := range remoteWorkspaces {
+		script += "  echo '  " + w + "'\n"
+	}
+	script += "fi\n"
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "terraform"), []byte(script), 0755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return &terraform.Client{
+		Directory: t.TempDir(),
+		Logger:    zaptest.NewLogger(t),
+	}
+}
+
+func TestDrifter_checkRemoteWorkspacesMissing(t *testing.T) {
+	ctx := context.Background()
+	notif := &recordingNotification{}
+	d := testDrifter(t, &fakeAtlantis{}, notif)
+	d.Terraform = fakeTerraform(t, "default", "prod", "old")
+	require.NoError(t, os.Mkdir(filepath.Join(d.Terraform.Directory, "dir"), 0755))
+	var err error
+	d.WorkspaceFilter, err = filter.New(nil, []string{"scratch-*"})
+	require.NoError(t, err)
+	d.ProjectFilter, err = filter.New(nil, []string{"legacy"})
+	require.NoError(t, err)
+	check := func(workspaces ...atlantis.Workspace) *DirectoryResult {
+		res := &DirectoryResult{Dir: "dir"}
+		require.NoError(t, d.checkRemoteWorkspaces(ctx, "dir", workspaces, res))
+		return res
+	}
+
+	res := check(atlantis.Workspace{Name: "prod"}, atlantis.Workspace{Name: "staging"})
+	require.Equal(t, OutcomeExtraWorkspaces, res.Outcome)
+	require.Equal(t, []string{"old"}, res.ExtraWorkspaces)
+	require.Equal(t, []string{"staging"}, res.MissingWorkspaces)
+	require.Equal(t, []string{"ExtraWorkspaceInRemote dir#old", "MissingWorkspaceInRemote dir#staging"}, notif.take())
+	cached, err := d.ResultCache.GetRemoteWorkspaces(ctx, &processedcache.ConsiderWorkspacesChecked{Dir: "dir"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"default", "prod", "old"}, cached.Workspaces)
+
+	// Two projects in the same workspace are only missing once, and filtered workspaces and projects are never missing
+	res = check(
+		atlantis.Workspace{Name: "prod"},
+		atlantis.Workspace{Name: "old"},
+		atlantis.Workspace{Name: "staging", ProjectName: "app"},
+		atlantis.Workspace{Name: "staging", ProjectName: "db"},
+		atlantis.Workspace{Name: "scratch-1"},
+		atlantis.Workspace{Name: "qa", ProjectName: "legacy"},
+	)
+	require.Equal(t, OutcomeMissingWorkspaces, res.Outcome)
+	require.Empty(t, res.ExtraWorkspaces)
+	require.Equal(t, []string{"staging"}, res.MissingWorkspaces)
+	require.Equal(t, []string{"MissingWorkspaceInRemote dir#staging"}, notif.take())
+
+	// An empty workspace name is the default workspace, which always exists
+	res = check(atlantis.Workspace{Name: ""}, atlantis.Workspace{Name: "prod"}, atlantis.Workspace{Name: "old"})
+	require.Equal(t, OutcomeNoDrift, res.Outcome)
+	require.Empty(t, res.MissingWorkspaces)
+	require.Empty(t, notif.take())
+}
@@ -0,0 +1,121 @@
+package drifter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/cresta/atlantis-drift-detection/internal/notification"
+	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
+	"go.uber.org/zap"
+)
+
+// failureBackoff returns how long to wait before checking again after failures checks in a row failed
+func (d *Drifter) failureBackoff(failures int) time.Duration {
+	return exponentialBackoff(failures, d.FailureBackoff, d.FailureMaxBackoff)
+}
+
+// inFailureBackoff returns true, and why, if a check that last failed at when should not be retried yet
+func (d *Drifter) inFailureBackoff(failures int, when time.Time) (bool, string) {
+	backoff := d.failureBackoff(failures)
+	if backoff <= 0 || time.Since(when) >= backoff {
+		return false, ""
+	}
+	return true, fmt.Sprintf("failed %d times in a row, next check in %s", failures, time.Until(when.Add(backoff)).Round(time.Second))
+}
+
+// interrupted returns true if a check failed only because the run was stopped under it, rather than because of
+// anything wrong with what it checked.  A check that hit its own timeout still counts as a failure.
+func (d *Drifter) interrupted(ctx context.Context, loc notification.Location) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+	d.Logger.Info("Check was interrupted, not counting it as a failure", zap.String("dir", loc.Directory), zap.String("workspace", loc.Workspace))
+	return true
+}
+
+// recordDriftCheckFailure remembers that checking a workspace for drift failed with checkErr, keeping what the last
+// successful check found so drift transitions still work once it recovers
+func (d *Drifter) recordDriftCheckFailure(ctx context.Context, dir string, ws atlantis.Workspace, checkErr error) error {
+	if d.DryRun || d.interrupted(ctx, location(dir, ws)) {
+		return nil
+	}
+	// Written even if the run is stopped while writing, so the count of failures in a row stays right
+	ctx = context.WithoutCancel(ctx)
+	cacheKey := &processedcache.ConsiderDriftChecked{
+		Dir:       dir,
+		Workspace: ws.Name,
+	}
+	prev, err := d.ResultCache.GetDriftCheckResult(ctx, cacheKey)
+	if err != nil {
+		return fmt.Errorf("failed to get cache value for %s/%s: %w", dir, ws.Name, err)
+	}
+	val := processedcache.DriftCheckValue{}
+	if prev != nil {
+		val = *prev
+	}
+	if val.Error == "" {
+		val.FailureCount = 0
+	}
+	val.FailureCount++
+	val.Error = checkErr.Error()
+	val.When = time.Now()
+	if err := d.ResultCache.StoreDriftCheckResult(ctx, cacheKey, &val); err != nil {
+		return fmt.Errorf("failed to store cache value for %s/%s: %w", dir, ws.Name, err)
+	}
+	return d.notifyPersistentFailure(ctx, location(dir, ws), val.FailureCount, checkErr)
+}
+
+// recordRemoteCheckFailure remembers that checking the remote workspaces of a directory failed with checkErr
+func (d *Drifter) recordRemoteCheckFailure(ctx context.Context, dir string, checkErr error) error {
+	if d.DryRun || d.interrupted(ctx, notification.Location{Directory: dir}) {
+		return nil
+	}
+	ctx = context.WithoutCancel(ctx)
+	cacheKey := &processedcache.ConsiderWorkspacesChecked{
+		Dir: dir,
+	}
+	prev, err := d.ResultCache.GetRemoteWorkspaces(ctx, cacheKey)
+	if err != nil {
+		return fmt.Errorf("failed to get cache value for %s: %w", dir, err)
+	}
+	val := processedcache.WorkspacesCheckedValue{}
+	if prev != nil {
+		val = *prev
+	}
+	if val.Error == "" {
+		val.FailureCount = 0
+	}
+	val.FailureCount++
+	val.Error = checkErr.Error()
+	val.When = time.Now()
+	if err := d.ResultCache.StoreRemoteWorkspaces(ctx, cacheKey, &val); err != nil {
+		return fmt.Errorf("failed to store cache value for %s: %w", dir, err)
+	}
+	return d.notifyPersistentFailure(ctx, notification.Location{Directory: dir}, val.FailureCount, checkErr)
+}
+
+// notifyPersistentFailure notifies once, when a check has failed exactly FailureNotifyThreshold times in a row
+func (d *Drifter) notifyPersistentFailure(ctx context.Context, loc notification.Location, failures int, checkErr error) error {
+	if d.FailureNotifyThreshold <= 0 || failures != d.FailureNotifyThreshold {
+		return nil
+	}
+	d.Logger.Warn("Check keeps failing", zap.String("dir", loc.Directory), zap.String("workspace", loc.Workspace), zap.Int("failures", failures), zap.Error(checkErr))
+	if err := d.Notification.PersistentFailure(ctx, loc, failures, checkErr); err != nil {
+		return fmt.Errorf("failed to notify of persistent failure in %s: %w", loc.Directory, err)
+	}
+	return nil
+}
+
+// checkFailure returns the error a workspace check failed with, or nil if it did not fail
+func checkFailure(res *WorkspaceResult, err error) error {
+	if err != nil {
+		return err
+	}
+	if res.Outcome == OutcomeTemporaryError {
+		return errors.New(res.Error)
+	}
+	return nil
+}
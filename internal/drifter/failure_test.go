@@ -0,0 +1,109 @@
+package drifter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrifter_inFailureBackoff(t *testing.T) {
+	d := Drifter{}
+	backoff, _ := d.inFailureBackoff(3, time.Now())
+	require.False(t, backoff)
+	d.FailureBackoff = time.Hour
+	d.FailureMaxBackoff = 3 * time.Hour
+	backoff, reason := d.inFailureBackoff(1, time.Now().Add(-30*time.Minute))
+	require.True(t, backoff)
+	require.Contains(t, reason, "failed 1 times in a row")
+	backoff, _ = d.inFailureBackoff(1, time.Now().Add(-2*time.Hour))
+	require.False(t, backoff)
+	backoff, _ = d.inFailureBackoff(2, time.Now().Add(-90*time.Minute))
+	require.True(t, backoff)
+	backoff, _ = d.inFailureBackoff(10, time.Now().Add(-4*time.Hour))
+	require.False(t, backoff)
+	// Without a maximum, many failures back off for as long as a time.Duration allows, rather than overflowing
+	d.FailureMaxBackoff = 0
+	backoff, _ = d.inFailureBackoff(100, time.Now().Add(-1000*time.Hour))
+	require.True(t, backoff)
+}
+
+func TestDrifter_findDriftedWorkspaceFailures(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeAtlantis{}
+	fake.setBody("not json")
+	notif := &recordingNotification{}
+	d := testDrifter(t, fake, notif)
+	d.ContinueOnError = true
+	d.FailureBackoff = time.Hour
+	d.FailureNotifyThreshold = 2
+	ws := atlantis.Workspace{Name: "prod"}
+	key := &processedcache.ConsiderDriftChecked{Dir: "dir", Workspace: "prod"}
+	check := func() *WorkspaceResult {
+		var report Report
+		require.NoError(t, d.findDriftedWorkspace(ctx, workspaceCheck{dir: "dir", workspace: ws}, &report))
+		require.Len(t, report.Workspaces, 1)
+		return report.Workspaces[0]
+	}
+	cached := func() *processedcache.DriftCheckValue {
+		val, err := d.ResultCache.GetDriftCheckResult(ctx, key)
+		require.NoError(t, err)
+		require.NotNil(t, val)
+		return val
+	}
+	age := func(by time.Duration) {
+		val := cached()
+		val.When = val.When.Add(-by)
+		require.NoError(t, d.ResultCache.StoreDriftCheckResult(ctx, key, val))
+	}
+
+	require.Equal(t, OutcomeError, check().Outcome)
+	require.Equal(t, 1, cached().FailureCount)
+	require.NotEmpty(t, cached().Error)
+	require.Empty(t, notif.take())
+
+	res := check()
+	require.Equal(t, OutcomeSkippedBackoff, res.Outcome)
+	require.Contains(t, res.Reason, "failed 1 times in a row")
+
+	age(2 * time.Hour)
+	require.Equal(t, OutcomeError, check().Outcome)
+	require.Equal(t, 2, cached().FailureCount)
+	require.Equal(t, []string{"PersistentFailure dir#prod"}, notif.take())
+
+	age(3 * time.Hour)
+	fake.setBody(changesResult)
+	require.Equal(t, OutcomeDrift, check().Outcome)
+	require.Equal(t, 0, cached().FailureCount)
+	require.Empty(t, cached().Error)
+	require.Equal(t, []string{"PlanDrift dir#prod"}, notif.take())
+}
+
+func TestDrifter_findDriftedWorkspaceInterrupted(t *testing.T) {
+	fake := &fakeAtlantis{}
+	fake.setBody(changesResult)
+	notif := &recordingNotification{}
+	d := testDrifter(t, fake, notif)
+	d.ContinueOnError = true
+	d.FailureBackoff = time.Hour
+	d.FailureNotifyThreshold = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var report Report
+	require.NoError(t, d.findDriftedWorkspace(ctx, workspaceCheck{dir: "dir", workspace: atlantis.Workspace{Name: "prod"}}, &report))
+	require.Empty(t, notif.take())
+	require.Len(t, report.Workspaces, 1)
+	require.NotEmpty(t, report.Workspaces[0].Error)
+
+	// Stopping the run is not a failure of the workspace, so it does not back off or notify
+	val, err := d.ResultCache.GetDriftCheckResult(context.Background(), &processedcache.ConsiderDriftChecked{Dir: "dir", Workspace: "prod"})
+	require.NoError(t, err)
+	require.Nil(t, val)
+	require.NoError(t, d.recordRemoteCheckFailure(ctx, "dir", context.Canceled))
+	remote, err := d.ResultCache.GetRemoteWorkspaces(context.Background(), &processedcache.ConsiderWorkspacesChecked{Dir: "dir"})
+	require.NoError(t, err)
+	require.Nil(t, remote)
+}
@@ -0,0 +1,272 @@
+package drifter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/cresta/atlantis-drift-detection/internal/filter"
+	"github.com/cresta/atlantis-drift-detection/internal/notification"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultIgnoreFile is where ignore rules are checked in, relative to the root of the terraform repo
+const DefaultIgnoreFile = ".drift-ignore.yaml"
+
+// IgnoreRule stops resource changes that are known to be harmless from counting as drift.  Every pattern that is set
+// must match, and an unset pattern matches everything.  Patterns are doublestar globs, or regular expressions with
+// the filter.RegexPrefix prefix.
+type IgnoreRule struct {
+	Dir       string `yaml:"dir"`
+	Workspace string `yaml:"workspace"`
+	// The resource address, like module.asg.aws_autoscaling_group.main
+	Address string `yaml:"address"`
+	// The resource type, like aws_autoscaling_group
+	Type string `yaml:"type"`
+	// Why the changes are harmless
+	Reason string `yaml:"reason"`
+
+	dir          *filter.Rule
+	workspace    *filter.Rule
+	address      *filter.Rule
+	resourceType *filter.Rule
+}
+
+func (r *IgnoreRule) String() string {
+	var parts []string
+	for _, p := range []struct{ name, pattern string }{{"dir", r.Dir}, {"workspace", r.Workspace}, {"address", r.Address}, {"type", r.Type}} {
+		if p.pattern != "" {
+			parts = append(parts, p.name+"="+p.pattern)
+		}
+	}
+	ret := strings.Join(parts, " ")
+	if r.Reason != "" {
+		ret += ": " + r.Reason
+	}
+	return ret
+}
+
+func (r *IgnoreRule) compile() error {
+	if r.Dir == "" && r.Workspace == "" && r.Address == "" && r.Type == "" {
+		return errors.New("rule has no patterns")
+	}
+	for _, p := range []struct {
+		pattern string
+		rule    **filter.Rule
+	}{{r.Dir, &r.dir}, {r.Workspace, &r.workspace}, {r.Address, &r.address}, {r.Type, &r.resourceType}} {
+		if p.pattern == "" {
+			continue
+		}
+		rule, err := filter.NewRule(p.pattern)
+		if err != nil {
+			return err
+		}
+		*p.rule = rule
+	}
+	return nil
+}
+
+// matchesLocation returns true if the rule applies to dir/workspace
+func (r *IgnoreRule) matchesLocation(dir string, workspace string) bool {
+	return (r.dir == nil || r.dir.Matches(dir)) && (r.workspace == nil || r.workspace.Matches(workspace))
+}
+
+// matchesResource returns true if the rule ignores change, assuming it applies to the change's dir/workspace
+func (r *IgnoreRule) matchesResource(change notification.ResourceChange) bool {
+	return (r.address == nil || r.address.Matches(change.Address)) && (r.resourceType == nil || r.resourceType.Matches(resourceType(change.Address)))
+}
+
+type ignoreFile struct {
+	Ignore []*IgnoreRule `yaml:"ignore"`
+}
+
+// ParseIgnoreRules reads an ignore rules file.  A file that does not exist has no rules.
+func ParseIgnoreRules(filename string) ([]*IgnoreRule, error) {
+	body, err := os.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read ignore file %s: %w", filename, err)
+	}
+	var ret ignoreFile
+	if err := decodeStrictYAML(body, &ret); err != nil {
+		return nil, fmt.Errorf("failed to parse ignore file %s: %w", filename, err)
+	}
+	for i, r := range ret.Ignore {
+		if r == nil {
+			return nil, fmt.Errorf("ignore rule %d in %s is empty", i, filename)
+		}
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("invalid ignore rule %d in %s: %w", i, filename, err)
+		}
+	}
+	return ret.Ignore, nil
+}
+
+// decodeStrictYAML decodes body into out, failing on keys out does not have, so that a misspelled pattern is an error
+// rather than a rule that matches more than it should.  An empty body decodes to nothing.
+func decodeStrictYAML(body []byte, out any) error {
+	dec := yaml.NewDecoder(bytes.NewReader(body))
+	dec.KnownFields(true)
+	if err := dec.Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// resourceType returns the type of the resource at address, like aws_instance for module.web.aws_instance.main[0]
+func resourceType(address string) string {
+	parts := splitAddress(address)
+	for i := 0; i < len(parts); i++ {
+		switch parts[i] {
+		case "module":
+			i++
+		case "data":
+			if i+1 < len(parts) {
+				return parts[i+1]
+			}
+		default:
+			return parts[i]
+		}
+	}
+	return ""
+}
+
+// splitAddress splits a resource address on the dots that are not inside an index, like ["a.b"]
+func splitAddress(address string) []string {
+	var ret []string
+	depth := 0
+	start := 0
+	for i, c := range address {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '.':
+			if depth == 0 {
+				ret = append(ret, address[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(ret, address[start:])
+}
+
+// applyIgnoreRules takes the resource changes ignore rules match out of changes, and records them in res.  It returns
+// the changes that are left, and whether they still count as drift.  Changes the plan counts but the parser did not
+// find are never ignored, so they keep the workspace drifted.
+func (d *Drifter) applyIgnoreRules(dir string, workspace string, changes notification.PlanChanges, res *WorkspaceResult) (notification.PlanChanges, bool) {
+	kept, ignored, all := d.ignoreChanges(dir, workspace, changes.Resources)
+	for _, c := range ignored {
+		d.Logger.Info("Ignoring resource change", zap.String("dir", dir), zap.String("workspace", workspace), zap.String("address", c.Address), zap.String("action", c.Action), zap.String("rule", c.Rule))
+	}
+	res.IgnoredChanges = ignored
+	if all != nil {
+		res.Reason = "all changes ignored by " + all.String()
+		return countChanges(changes, nil), false
+	}
+	if len(ignored) == 0 {
+		return changes, true
+	}
+	ignoredResources := make([]notification.ResourceChange, 0, len(ignored))
+	for _, c := range ignored {
+		ignoredResources = append(ignoredResources, c.ResourceChange)
+	}
+	left := withoutChanges(changes, countChanges(changes, ignoredResources))
+	left.Resources = kept
+	if len(kept) == 0 && left.Import == 0 && left.Add == 0 && left.Change == 0 && left.Destroy == 0 {
+		res.Reason = fmt.Sprintf("all %d changes ignored", len(ignored))
+		return left, false
+	}
+	return left, true
+}
+
+// withoutChanges returns the totals of changes, less the totals of removed
+func withoutChanges(changes notification.PlanChanges, removed notification.PlanChanges) notification.PlanChanges {
+	return notification.PlanChanges{
+		Import:         max(changes.Import-removed.Import, 0),
+		Add:            max(changes.Add-removed.Add, 0),
+		Change:         max(changes.Change-removed.Change, 0),
+		Destroy:        max(changes.Destroy-removed.Destroy, 0),
+		ChangesOutside: changes.ChangesOutside,
+	}
+}
+
+// ignoreChanges splits the resource changes of dir/workspace into the ones that count as drift, and the ones an
+// ignore rule matched.  If a rule matches dir/workspace without any resource patterns, every change is ignored and
+// that rule is returned.
+func (d *Drifter) ignoreChanges(dir string, workspace string, changes []notification.ResourceChange) ([]notification.ResourceChange, []IgnoredChange, *IgnoreRule) {
+	var rules []*IgnoreRule
+	for _, r := range d.ignoreRules {
+		if !r.matchesLocation(dir, workspace) {
+			continue
+		}
+		if r.address == nil && r.resourceType == nil {
+			return nil, ignoredBy(changes, r), r
+		}
+		rules = append(rules, r)
+	}
+	var kept []notification.ResourceChange
+	var ignored []IgnoredChange
+	for _, c := range changes {
+		idx := -1
+		for i, r := range rules {
+			if r.matchesResource(c) {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			kept = append(kept, c)
+			continue
+		}
+		ignored = append(ignored, IgnoredChange{ResourceChange: c, Rule: rules[idx].String()})
+	}
+	return kept, ignored, nil
+}
+
+func ignoredBy(changes []notification.ResourceChange, r *IgnoreRule) []IgnoredChange {
+	ret := make([]IgnoredChange, 0, len(changes))
+	for _, c := range changes {
+		ret = append(ret, IgnoredChange{ResourceChange: c, Rule: r.String()})
+	}
+	return ret
+}
+
+// resourcesAddUp returns true if the resource changes found in a plan account for every change its totals count.  If
+// not, the plan changes resources the parser did not recognize.
+func resourcesAddUp(changes notification.PlanChanges) bool {
+	counted := countChanges(changes, changes.Resources)
+	return counted.Import == changes.Import && counted.Add == changes.Add && counted.Change == changes.Change && counted.Destroy == changes.Destroy
+}
+
+// countChanges recounts what changes would do, the way terraform counts them at the end of a plan
+func countChanges(changes notification.PlanChanges, resources []notification.ResourceChange) notification.PlanChanges {
+	ret := notification.PlanChanges{
+		ChangesOutside: changes.ChangesOutside,
+		Resources:      resources,
+	}
+	for _, r := range resources {
+		switch atlantis.ResourceAction(r.Action) {
+		case atlantis.ActionCreate:
+			ret.Add++
+		case atlantis.ActionUpdate:
+			ret.Change++
+		case atlantis.ActionReplace:
+			ret.Add++
+			ret.Destroy++
+		case atlantis.ActionDelete:
+			ret.Destroy++
+		case atlantis.ActionImport:
+			ret.Import++
+		}
+	}
+	return ret
+}
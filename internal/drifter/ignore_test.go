@@ -0,0 +1,131 @@
+package drifter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/cresta/atlantis-drift-detection/internal/notification"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIgnoreRules(t *testing.T) {
+	td := t.TempDir()
+	rules, err := ParseIgnoreRules(filepath.Join(td, DefaultIgnoreFile))
+	require.NoError(t, err)
+	require.Empty(t, rules)
+
+	filename := filepath.Join(td, "ignore.yaml")
+	require.NoError(t, os.WriteFile(filename, []byte(`ignore:
+  - type: aws_autoscaling_group
+    reason: desired count is managed by the autoscaler
+  - dir: environments/aws/**
+    address: regex:\.tags_all$
+`), 0644))
+	rules, err = ParseIgnoreRules(filename)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	require.Equal(t, "type=aws_autoscaling_group: desired count is managed by the autoscaler", rules[0].String())
+	require.Equal(t, `dir=environments/aws/** address=regex:\.tags_all$`, rules[1].String())
+
+	require.NoError(t, os.WriteFile(filename, []byte("ignore:\n  - reason: no patterns\n"), 0644))
+	_, err = ParseIgnoreRules(filename)
+	require.Error(t, err)
+	require.NoError(t, os.WriteFile(filename, []byte("ignore:\n  - address: \"regex:[\"\n"), 0644))
+	_, err = ParseIgnoreRules(filename)
+	require.Error(t, err)
+	require.NoError(t, os.WriteFile(filename, []byte("ignore:\n  -\n"), 0644))
+	_, err = ParseIgnoreRules(filename)
+	require.Error(t, err)
+	// A misspelled key would otherwise leave a rule that ignores every change in the directory
+	require.NoError(t, os.WriteFile(filename, []byte("ignore:\n  - dir: environments/aws/**\n    adress: aws_instance.web\n"), 0644))
+	_, err = ParseIgnoreRules(filename)
+	require.ErrorContains(t, err, "adress")
+	require.NoError(t, os.WriteFile(filename, nil, 0644))
+	rules, err = ParseIgnoreRules(filename)
+	require.NoError(t, err)
+	require.Empty(t, rules)
+}
+
+func TestResourceType(t *testing.T) {
+	require.Equal(t, "aws_instance", resourceType("aws_instance.web"))
+	require.Equal(t, "aws_instance", resourceType(`module.web["a.b"].module.inner.aws_instance.main[0]`))
+	require.Equal(t, "aws_ami", resourceType("module.web.data.aws_ami.latest"))
+}
+
+func TestResourcesAddUp(t *testing.T) {
+	require.True(t, resourcesAddUp(notification.PlanChanges{}))
+	require.True(t, resourcesAddUp(notification.PlanChanges{
+		Add:     1,
+		Destroy: 1,
+		Resources: []notification.ResourceChange{
+			{Address: "aws_instance.web", Action: "replace"},
+			{Address: "aws_security_group.a", Action: "move"},
+		},
+	}))
+	require.False(t, resourcesAddUp(notification.PlanChanges{
+		Change:    2,
+		Resources: []notification.ResourceChange{{Address: "aws_instance.web", Action: "update"}},
+	}))
+	require.False(t, resourcesAddUp(notification.PlanChanges{
+		Destroy:   1,
+		Resources: []notification.ResourceChange{{Address: "aws_iam_policy.legacy", Action: "unknown"}},
+	}))
+}
+
+func TestDrifter_checkWorkspaceIgnored(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeAtlantis{}
+	notif := &recordingNotification{}
+	d := testDrifter(t, fake, notif)
+	ws := atlantis.Workspace{Name: "prod"}
+	td := t.TempDir()
+	filename := filepath.Join(td, DefaultIgnoreFile)
+	require.NoError(t, os.WriteFile(filename, []byte(`ignore:
+  - type: aws_autoscaling_group
+  - dir: other
+`), 0644))
+	var err error
+	d.ignoreRules, err = ParseIgnoreRules(filename)
+	require.NoError(t, err)
+	check := func(dir string, output string) *WorkspaceResult {
+		fake.setBody(`{"ProjectResults":[{"PlanSuccess":{"TerraformOutput":` + output + `}}]}`)
+		var res WorkspaceResult
+		require.NoError(t, d.checkWorkspace(ctx, workspaceCheck{dir: dir, workspace: ws}, &res))
+		return &res
+	}
+
+	res := check("dir", `"  # module.asg.aws_autoscaling_group.main will be updated in-place\n\nPlan: 0 to add, 1 to change, 0 to destroy."`)
+	require.Equal(t, OutcomeNoDrift, res.Outcome)
+	require.Equal(t, "all 1 changes ignored", res.Reason)
+	require.Equal(t, &notification.PlanChanges{}, res.Changes)
+	require.Equal(t, []IgnoredChange{{
+		ResourceChange: notification.ResourceChange{Address: "module.asg.aws_autoscaling_group.main", Action: "update"},
+		Rule:           "type=aws_autoscaling_group",
+	}}, res.IgnoredChanges)
+	require.Empty(t, notif.take())
+
+	res = check("dir", `"  # module.asg.aws_autoscaling_group.main will be updated in-place\n  # aws_instance.web must be replaced\n\nPlan: 1 to add, 1 to change, 1 to destroy."`)
+	require.Equal(t, OutcomeDrift, res.Outcome)
+	require.Equal(t, &notification.PlanChanges{
+		Add:       1,
+		Destroy:   1,
+		Resources: []notification.ResourceChange{{Address: "aws_instance.web", Action: "replace"}},
+	}, res.Changes)
+	require.Len(t, res.IgnoredChanges, 1)
+	require.Equal(t, []string{"PlanDrift dir#prod"}, notif.take())
+
+	// The plan changes one more resource than it lists, which an ignore rule cannot have matched
+	res = check("unlisted", `"  # module.asg.aws_autoscaling_group.main will be updated in-place\n\nPlan: 0 to add, 2 to change, 0 to destroy."`)
+	require.Equal(t, OutcomeDrift, res.Outcome)
+	require.Equal(t, &notification.PlanChanges{Change: 1}, res.Changes)
+	require.Len(t, res.IgnoredChanges, 1)
+	require.Equal(t, []string{"PlanDrift unlisted#prod"}, notif.take())
+
+	res = check("other", `"Plan: 1 to add, 0 to change, 0 to destroy."`)
+	require.Equal(t, OutcomeNoDrift, res.Outcome)
+	require.Equal(t, "all changes ignored by dir=other", res.Reason)
+	require.Empty(t, notif.take())
+}
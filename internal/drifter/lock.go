@@ -0,0 +1,81 @@
+package drifter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/cresta/atlantis-drift-detection/internal/notification"
+	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
+	"github.com/runatlantis/atlantis/server/controllers"
+	"go.uber.org/zap"
+)
+
+// lockHolder returns who holds the lock on dir/workspace.  The atlantis locks API fills in what the plan failure
+// leaves out, and the cache remembers when we first saw a lock whose age atlantis does not tell us.
+func (d *Drifter) lockHolder(ctx context.Context, dir string, workspace string, parsed *atlantis.LockHolder, previous *processedcache.DriftCheckValue) notification.Lock {
+	holder := parsed
+	if holder == nil {
+		holder = &atlantis.LockHolder{}
+	}
+	locks, err := d.listLocks(ctx)
+	if err != nil {
+		d.Logger.Warn("Unable to list atlantis locks", zap.String("dir", dir), zap.String("workspace", workspace), zap.Error(err))
+	} else if found := atlantis.FindLock(locks, d.Repo, dir, workspace); found != nil {
+		holder = found
+	}
+	ret := notification.Lock{
+		PullID:  holder.PullID,
+		PullURL: holder.PullURL,
+		User:    holder.User,
+		Since:   holder.Time,
+	}
+	if ret.Since.IsZero() {
+		ret.Since = time.Now()
+		if previous != nil && !previous.LockedSince.IsZero() && previous.LockPullID == ret.PullID {
+			ret.Since = previous.LockedSince
+		}
+	}
+	return ret
+}
+
+// runLocks holds the atlantis locks of one run, listed the first time a locked workspace needs them, so that a run
+// with many locked workspaces asks atlantis for every lock only once
+type runLocks struct {
+	mu     sync.Mutex
+	listed bool
+	locks  []controllers.LockDetail
+	err    error
+}
+
+// listLocks returns every atlantis lock, listing them at most once per run
+func (d *Drifter) listLocks(ctx context.Context) ([]controllers.LockDetail, error) {
+	l := d.locks
+	if l == nil {
+		return d.AtlantisClient.ListLocks(ctx)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.listed {
+		locks, err := d.AtlantisClient.ListLocks(ctx)
+		// A check that timed out or was cancelled says nothing about atlantis, so the next one tries again
+		if err != nil && ctx.Err() != nil {
+			return nil, err
+		}
+		l.listed = true
+		l.locks, l.err = locks, err
+	}
+	return l.locks, l.err
+}
+
+// lockBecameStale returns true if lock got older than StaleLockThreshold since the previous check
+func (d *Drifter) lockBecameStale(lock notification.Lock, previous *processedcache.DriftCheckValue) bool {
+	if d.StaleLockThreshold <= 0 || time.Since(lock.Since) < d.StaleLockThreshold {
+		return false
+	}
+	if previous == nil || !previous.LockedSince.Equal(lock.Since) {
+		return true
+	}
+	return previous.When.Sub(lock.Since) < d.StaleLockThreshold
+}
@@ -0,0 +1,76 @@
+package drifter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
+	"github.com/stretchr/testify/require"
+)
+
+const lockedResult = `{"ProjectResults":[{"Failure":"This project is currently locked by an unapplied plan from pull #123. To continue, delete the lock from #123 or apply that plan and merge the pull request."}]}`
+
+func TestDrifter_checkWorkspaceStaleLock(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeAtlantis{}
+	fake.setBody(lockedResult)
+	notif := &recordingNotification{}
+	d := testDrifter(t, fake, notif)
+	d.StaleLockThreshold = time.Hour
+	ws := atlantis.Workspace{Name: "prod"}
+	key := &processedcache.ConsiderDriftChecked{Dir: "dir", Workspace: "prod"}
+
+	var res WorkspaceResult
+	require.NoError(t, d.checkWorkspace(ctx, workspaceCheck{dir: "dir", workspace: ws}, &res))
+	require.Equal(t, OutcomeLocked, res.Outcome)
+	require.Equal(t, 123, res.Lock.PullID)
+	require.Empty(t, notif.take())
+
+	// Pretend we first saw the lock two hours ago, and last checked before it was an hour old
+	val, err := d.ResultCache.GetDriftCheckResult(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, 123, val.LockPullID)
+	val.LockedSince = time.Now().Add(-2 * time.Hour)
+	val.When = time.Now().Add(-90 * time.Minute)
+	require.NoError(t, d.ResultCache.StoreDriftCheckResult(ctx, key, val))
+	res = WorkspaceResult{}
+	require.NoError(t, d.checkWorkspace(ctx, workspaceCheck{dir: "dir", workspace: ws}, &res))
+	require.True(t, res.Notified)
+	require.Equal(t, val.LockedSince, res.Lock.Since)
+	require.Equal(t, []string{"StaleLock dir#prod"}, notif.take())
+
+	// Only notified once per lock
+	require.NoError(t, d.checkWorkspace(ctx, workspaceCheck{dir: "dir", workspace: ws}, &res))
+	require.Empty(t, notif.take())
+}
+
+func TestDrifter_lockHolderListsLocksOncePerRun(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeAtlantis{
+		locks: `{"Locks":[{"ProjectRepo":"cresta/terraform","ProjectRepoPath":"dir","PullID":"45","PullURL":"https://github.com/cresta/terraform/pull/45","User":"someone","Workspace":"default","Time":"2026-01-02T03:04:05Z"}]}`,
+	}
+	fake.setBody(lockedResult)
+	d := testDrifter(t, fake, &recordingNotification{})
+	d.locks = &runLocks{}
+
+	// An unset atlantis workspace is the default workspace the lock is on
+	var res WorkspaceResult
+	require.NoError(t, d.checkWorkspace(ctx, workspaceCheck{dir: "dir", workspace: atlantis.Workspace{}}, &res))
+	require.Equal(t, OutcomeLocked, res.Outcome)
+	require.Equal(t, 45, res.Lock.PullID)
+	require.Equal(t, "someone", res.Lock.User)
+	require.Equal(t, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), res.Lock.Since)
+
+	res = WorkspaceResult{}
+	require.NoError(t, d.checkWorkspace(ctx, workspaceCheck{dir: "dir", workspace: atlantis.Workspace{Name: "prod"}}, &res))
+	require.Equal(t, 123, res.Lock.PullID)
+	require.Equal(t, 1, fake.countLockRequests())
+
+	// The next run lists them again
+	d.locks = &runLocks{}
+	res = WorkspaceResult{}
+	require.NoError(t, d.checkWorkspace(ctx, workspaceCheck{dir: "dir", workspace: atlantis.Workspace{Name: "prod"}}, &res))
+	require.Equal(t, 2, fake.countLockRequests())
+}
@@ -0,0 +1,153 @@
+package drifter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
+	"golang.org/x/sync/errgroup"
+)
+
+// Order is the order in which a run checks directories and workspaces
+type Order string
+
+const (
+	// OrderAlphabetical checks directories, and the workspaces inside them, in sorted order
+	OrderAlphabetical Order = "alphabetical"
+	// OrderStaleness checks whatever was checked longest ago, or never, first
+	OrderStaleness Order = "staleness"
+)
+
+// ParseOrder parses an Order.  An empty string is alphabetical.
+func ParseOrder(s string) (Order, error) {
+	switch Order(s) {
+	case "", OrderAlphabetical:
+		return OrderAlphabetical, nil
+	case OrderStaleness:
+		return OrderStaleness, nil
+	}
+	return "", fmt.Errorf("unknown order %q: expected %s or %s", s, OrderAlphabetical, OrderStaleness)
+}
+
+// workspaceCheck is one directory/workspace pair to check for drift
+type workspaceCheck struct {
+	dir       string
+	workspace atlantis.Workspace
+	// If cacheRead is set, cached is the drift check result that ordering read, so the check does not read it again
+	cached    *processedcache.DriftCheckValue
+	cacheRead bool
+}
+
+// orderWorkspaces returns every directory/workspace pair of ws this shard owns, in the order they should be checked.
+// Pairs another shard owns are left out, so that they are neither checked nor reported here.
+func (d *Drifter) orderWorkspaces(ctx context.Context, ws atlantis.DirectoriesWithWorkspaces) ([]workspaceCheck, error) {
+	checks := make([]workspaceCheck, 0, len(ws))
+	for _, dir := range ws.SortedKeys() {
+		for _, workspace := range ws[dir] {
+			if d.Shard.Owns(dir, workspace.Name) {
+				checks = append(checks, workspaceCheck{dir: dir, workspace: workspace})
+			}
+		}
+	}
+	if d.Order != OrderStaleness {
+		return checks, nil
+	}
+	err := d.forEachParallel(ctx, len(checks), func(ctx context.Context, i int) error {
+		c := &checks[i]
+		val, err := d.ResultCache.GetDriftCheckResult(ctx, &processedcache.ConsiderDriftChecked{
+			Dir:       c.dir,
+			Workspace: c.workspace.Name,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get cache value for %s/%s: %w", c.dir, c.workspace.Name, err)
+		}
+		c.cached = val
+		c.cacheRead = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	lastChecked := make([]time.Time, len(checks))
+	for i, c := range checks {
+		if c.cached != nil {
+			lastChecked[i] = c.cached.When
+		}
+	}
+	idx := make([]int, len(checks))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return lastChecked[idx[i]].Before(lastChecked[idx[j]])
+	})
+	ret := make([]workspaceCheck, len(checks))
+	for i, from := range idx {
+		ret[i] = checks[from]
+	}
+	return ret, nil
+}
+
+// groupByDirectory groups checks by directory.  Directories are returned in the order they first appear in checks.
+func groupByDirectory(checks []workspaceCheck) ([]string, map[string][]workspaceCheck) {
+	dirs := make([]string, 0)
+	grouped := make(map[string][]workspaceCheck)
+	for _, c := range checks {
+		if _, exists := grouped[c.dir]; !exists {
+			dirs = append(dirs, c.dir)
+		}
+		grouped[c.dir] = append(grouped[c.dir], c)
+	}
+	return dirs, grouped
+}
+
+// orderDirectories returns dirs in the order their remote workspaces should be checked
+func (d *Drifter) orderDirectories(ctx context.Context, dirs []string) ([]string, error) {
+	if d.Order != OrderStaleness {
+		return dirs, nil
+	}
+	lastChecked := make([]time.Time, len(dirs))
+	err := d.forEachParallel(ctx, len(dirs), func(ctx context.Context, i int) error {
+		val, err := d.ResultCache.GetRemoteWorkspaces(ctx, &processedcache.ConsiderWorkspacesChecked{
+			Dir: dirs[i],
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get cache value for %s: %w", dirs[i], err)
+		}
+		if val != nil {
+			lastChecked[i] = val.When
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	idx := make([]int, len(dirs))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return lastChecked[idx[i]].Before(lastChecked[idx[j]])
+	})
+	ret := make([]string, len(dirs))
+	for i, from := range idx {
+		ret[i] = dirs[from]
+	}
+	return ret, nil
+}
+
+// forEachParallel calls f for every index below n, ParallelRuns at a time, so that ordering many workspaces does not
+// wait on one cache read after another
+func (d *Drifter) forEachParallel(ctx context.Context, n int, f func(ctx context.Context, i int) error) error {
+	eg, egctx := errgroup.WithContext(ctx)
+	eg.SetLimit(max(d.ParallelRuns, 1))
+	for i := 0; i < n; i++ {
+		eg.Go(func() error {
+			return f(egctx, i)
+		})
+	}
+	return eg.Wait()
+}
@@ -0,0 +1,98 @@
+package drifter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOrder(t *testing.T) {
+	o, err := ParseOrder("")
+	require.NoError(t, err)
+	require.Equal(t, OrderAlphabetical, o)
+	o, err = ParseOrder("staleness")
+	require.NoError(t, err)
+	require.Equal(t, OrderStaleness, o)
+	_, err = ParseOrder("random")
+	require.Error(t, err)
+}
+
+func TestDrifter_orderWorkspaces(t *testing.T) {
+	ctx := context.Background()
+	cache := &processedcache.Memory{}
+	now := time.Now()
+	store := func(dir string, workspace string, age time.Duration) {
+		require.NoError(t, cache.StoreDriftCheckResult(ctx, &processedcache.ConsiderDriftChecked{Dir: dir, Workspace: workspace}, &processedcache.DriftCheckValue{When: now.Add(-age)}))
+	}
+	store("a", "dev", time.Hour)
+	store("a", "prod", 3*time.Hour)
+	store("b", "dev", 2*time.Hour)
+	store("c", "dev", time.Minute)
+	ws := atlantis.DirectoriesWithWorkspaces{
+		"a": {{Name: "dev"}, {Name: "prod"}},
+		"b": {{Name: "dev"}},
+		"c": {{Name: "dev"}, {Name: "new"}},
+	}
+
+	d := Drifter{ResultCache: cache}
+	checks, err := d.orderWorkspaces(ctx, ws)
+	require.NoError(t, err)
+	dirs, grouped := groupByDirectory(checks)
+	require.Equal(t, []string{"a", "b", "c"}, dirs)
+	for dir, workspaces := range ws {
+		require.Equal(t, workspaces, checkedWorkspaces(grouped[dir]))
+	}
+	for _, c := range checks {
+		require.False(t, c.cacheRead)
+	}
+
+	d.Order = OrderStaleness
+	d.ParallelRuns = 3
+	checks, err = d.orderWorkspaces(ctx, ws)
+	require.NoError(t, err)
+	locations := make([]string, 0, len(checks))
+	for _, c := range checks {
+		locations = append(locations, c.dir+"#"+c.workspace.Name)
+		require.True(t, c.cacheRead)
+	}
+	require.Equal(t, []string{"c#new", "a#prod", "b#dev", "a#dev", "c#dev"}, locations)
+	require.Nil(t, checks[0].cached)
+	require.Equal(t, now.Add(-3*time.Hour), checks[1].cached.When)
+	dirs, grouped = groupByDirectory(checks)
+	require.Equal(t, []string{"c", "a", "b"}, dirs)
+	require.Equal(t, []atlantis.Workspace{{Name: "new"}, {Name: "dev"}}, checkedWorkspaces(grouped["c"]))
+	require.Equal(t, []atlantis.Workspace{{Name: "prod"}, {Name: "dev"}}, checkedWorkspaces(grouped["a"]))
+
+	require.NoError(t, cache.StoreRemoteWorkspaces(ctx, &processedcache.ConsiderWorkspacesChecked{Dir: "a"}, &processedcache.WorkspacesCheckedValue{When: now}))
+	require.NoError(t, cache.StoreRemoteWorkspaces(ctx, &processedcache.ConsiderWorkspacesChecked{Dir: "b"}, &processedcache.WorkspacesCheckedValue{When: now.Add(-time.Hour)}))
+	dirs, err = d.orderDirectories(ctx, []string{"a", "b", "c"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "b", "a"}, dirs)
+}
+
+func TestDrifter_checkWorkspaceReusesOrderingRead(t *testing.T) {
+	d := testDrifter(t, &fakeAtlantis{}, &recordingNotification{})
+	d.CacheValidDuration = time.Hour
+	// The cache itself is empty, so only the value read while ordering can make the check skip
+	c := workspaceCheck{
+		dir:       "dir",
+		workspace: atlantis.Workspace{Name: "prod"},
+		cached:    &processedcache.DriftCheckValue{When: time.Now()},
+		cacheRead: true,
+	}
+	var res WorkspaceResult
+	require.NoError(t, d.checkWorkspace(context.Background(), c, &res))
+	require.Equal(t, OutcomeSkippedCache, res.Outcome)
+}
+
+func checkedWorkspaces(checks []workspaceCheck) []atlantis.Workspace {
+	ret := make([]atlantis.Workspace, 0, len(checks))
+	for _, c := range checks {
+		ret = append(ret, c.workspace)
+	}
+	return ret
+}
@@ -0,0 +1,102 @@
+package drifter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ParallelUnit is what ParallelRuns runs in parallel
+type ParallelUnit string
+
+const (
+	// ParallelDirectory checks whole directories in parallel, and the workspaces of each directory one at a time
+	ParallelDirectory ParallelUnit = "directory"
+	// ParallelWorkspace checks single directory/workspace pairs in parallel
+	ParallelWorkspace ParallelUnit = "workspace"
+)
+
+// ParseParallelUnit parses a ParallelUnit.  An empty string is ParallelDirectory.
+func ParseParallelUnit(s string) (ParallelUnit, error) {
+	switch ParallelUnit(s) {
+	case "", ParallelDirectory:
+		return ParallelDirectory, nil
+	case ParallelWorkspace:
+		return ParallelWorkspace, nil
+	}
+	return "", fmt.Errorf("unknown parallel unit %q: expected %s or %s", s, ParallelDirectory, ParallelWorkspace)
+}
+
+// drainByDirectory runs run for every check, ParallelRuns at a time, and never more than MaxParallelPerDirectory
+// for one directory.  Checks start in order, except that a check whose directory is at its limit lets later checks
+// of other directories go first.
+func (d *Drifter) drainByDirectory(ctx context.Context, checks []workspaceCheck, run func(ctx context.Context, c workspaceCheck) error) error {
+	if d.ParallelRuns <= 1 {
+		for _, c := range checks {
+			if err := run(ctx, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	eg, egctx := errgroup.WithContext(ctx)
+	var mu sync.Mutex
+	cond := sync.NewCond(&mu)
+	stop := context.AfterFunc(egctx, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		cond.Broadcast()
+	})
+	defer stop()
+	pending := append([]workspaceCheck(nil), checks...)
+	running := make(map[string]int)
+	// Set under mu as soon as a check fails, so no worker woken by that check finishing starts another one before the
+	// error cancels egctx
+	failed := false
+	// next blocks until a check can start, returning false once there is nothing left to start
+	next := func() (workspaceCheck, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		for {
+			if failed || egctx.Err() != nil || len(pending) == 0 {
+				return workspaceCheck{}, false
+			}
+			for i, c := range pending {
+				if d.MaxParallelPerDirectory > 0 && running[c.dir] >= d.MaxParallelPerDirectory {
+					continue
+				}
+				pending = append(pending[:i], pending[i+1:]...)
+				running[c.dir]++
+				return c, true
+			}
+			cond.Wait()
+		}
+	}
+	done := func(c workspaceCheck, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		running[c.dir]--
+		if err != nil {
+			failed = true
+		}
+		cond.Broadcast()
+	}
+	for i := 0; i < d.ParallelRuns; i++ {
+		eg.Go(func() error {
+			for {
+				c, ok := next()
+				if !ok {
+					return egctx.Err()
+				}
+				err := run(egctx, c)
+				done(c, err)
+				if err != nil {
+					return err
+				}
+			}
+		})
+	}
+	return eg.Wait()
+}
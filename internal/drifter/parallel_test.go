@@ -0,0 +1,72 @@
+package drifter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseParallelUnit(t *testing.T) {
+	u, err := ParseParallelUnit("")
+	require.NoError(t, err)
+	require.Equal(t, ParallelDirectory, u)
+	u, err = ParseParallelUnit("workspace")
+	require.NoError(t, err)
+	require.Equal(t, ParallelWorkspace, u)
+	_, err = ParseParallelUnit("project")
+	require.Error(t, err)
+}
+
+func TestDrifter_drainByDirectory(t *testing.T) {
+	d := Drifter{
+		ParallelRuns:            4,
+		MaxParallelPerDirectory: 2,
+	}
+	var checks []workspaceCheck
+	for _, dir := range []string{"a", "b"} {
+		for _, ws := range []string{"1", "2", "3", "4", "5"} {
+			checks = append(checks, workspaceCheck{dir: dir, workspace: atlantis.Workspace{Name: ws}})
+		}
+	}
+	var mu sync.Mutex
+	running := make(map[string]int)
+	maxRunning := make(map[string]int)
+	total := 0
+	err := d.drainByDirectory(context.Background(), checks, func(_ context.Context, c workspaceCheck) error {
+		mu.Lock()
+		running[c.dir]++
+		maxRunning[c.dir] = max(maxRunning[c.dir], running[c.dir])
+		total++
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running[c.dir]--
+		mu.Unlock()
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 10, total)
+	require.Equal(t, 2, maxRunning["a"])
+	require.Equal(t, 2, maxRunning["b"])
+}
+
+func TestDrifter_drainByDirectoryStopsOnError(t *testing.T) {
+	d := Drifter{
+		ParallelRuns:            2,
+		MaxParallelPerDirectory: 1,
+	}
+	checks := []workspaceCheck{{dir: "a"}, {dir: "a"}, {dir: "a"}}
+	var calls atomic.Int32
+	err := d.drainByDirectory(context.Background(), checks, func(_ context.Context, c workspaceCheck) error {
+		calls.Add(1)
+		return errors.New("bad plan")
+	})
+	require.EqualError(t, err, "bad plan")
+	require.Equal(t, int32(1), calls.Load())
+}
@@ -0,0 +1,238 @@
+package drifter
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/notification"
+)
+
+// Outcome is the result of checking a single directory/workspace for drift
+type Outcome string
+
+const (
+	OutcomeDrift          Outcome = "drift"
+	OutcomeNoDrift        Outcome = "no_drift"
+	OutcomeLocked         Outcome = "locked"
+	OutcomeTemporaryError Outcome = "temporary_error"
+	OutcomeError          Outcome = "error"
+	OutcomeSkippedCache   Outcome = "skipped_cache"
+	// The check failed the last few times, and is waiting out FailureBackoff before trying again
+	OutcomeSkippedBackoff  Outcome = "skipped_backoff"
+	OutcomeSkippedFilter   Outcome = "skipped_filter"
+	OutcomeExtraWorkspaces Outcome = "extra_workspaces"
+	// Workspaces atlantis expects are missing from the remote, and none are extra
+	OutcomeMissingWorkspaces Outcome = "missing_workspaces"
+	// The check took longer than the workspace timeout
+	OutcomeTimeout Outcome = "timeout"
+	// The run deadline passed before the check could start
+	OutcomeNotChecked Outcome = "not_checked"
+	// Dry runs only: the workspace would be planned
+	OutcomeWouldPlan Outcome = "would_plan"
+	// Dry runs only: the directory's remote workspaces would be listed
+	OutcomeWouldCheck Outcome = "would_check"
+)
+
+// WorkspaceResult is the report entry for one directory/workspace drift check
+type WorkspaceResult struct {
+	Dir       string `json:"dir"`
+	Workspace string `json:"workspace"`
+	// The atlantis project name, if atlantis.yaml gives the project one
+	ProjectName string    `json:"project_name,omitempty"`
+	Outcome     Outcome   `json:"outcome"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	// Why the check was skipped, why drift was snoozed or, in a dry run, why it would run
+	Reason string `json:"reason,omitempty"`
+	// Only if locked: who holds the lock
+	Lock *notification.Lock `json:"lock,omitempty"`
+	// True if the workspace drifted, but the drift is acknowledged so no notification was sent
+	Snoozed bool `json:"snoozed,omitempty"`
+	// The plan summaries atlantis returned, if we planned
+	PlanSummaries []string `json:"plan_summaries,omitempty"`
+	// What the plan would change, if we planned and the plan was not locked
+	Changes *notification.PlanChanges `json:"changes,omitempty"`
+	// Resource changes the plan would make that ignore rules matched, so they are not part of Changes
+	IgnoredChanges []IgnoredChange `json:"ignored_changes,omitempty"`
+	// True if this check sent a notification.  Drift is only notified when it first appears or is resolved.
+	Notified bool   `json:"notified,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// IgnoredChange is a resource change that an ignore rule kept from counting as drift
+type IgnoredChange struct {
+	notification.ResourceChange
+	// The ignore rule that matched
+	Rule string `json:"rule"`
+}
+
+// DirectoryResult is the report entry for one directory remote workspace check
+type DirectoryResult struct {
+	Dir     string    `json:"dir"`
+	Outcome Outcome   `json:"outcome"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	// Why the check was skipped or, in a dry run, why it would run
+	Reason string `json:"reason,omitempty"`
+	// Workspaces in the remote that atlantis does not know about
+	ExtraWorkspaces []string `json:"extra_workspaces,omitempty"`
+	// Workspaces atlantis expects that have no state in the remote
+	MissingWorkspaces []string `json:"missing_workspaces,omitempty"`
+	Error             string   `json:"error,omitempty"`
+}
+
+// Failure is a check that could not be completed
+type Failure struct {
+	Dir string `json:"dir"`
+	// Empty for directory level checks
+	Workspace string `json:"workspace,omitempty"`
+	Error     string `json:"error"`
+}
+
+func (f *Failure) String() string {
+	if f.Workspace == "" {
+		return fmt.Sprintf("%s: %s", f.Dir, f.Error)
+	}
+	return fmt.Sprintf("%s#%s: %s", f.Dir, f.Workspace, f.Error)
+}
+
+// Report is everything that happened during a single Drift call
+type Report struct {
+	Repo        string             `json:"repo"`
+	Start       time.Time          `json:"start"`
+	End         time.Time          `json:"end"`
+	Workspaces  []*WorkspaceResult `json:"workspaces"`
+	Directories []*DirectoryResult `json:"directories"`
+	Failures    []*Failure         `json:"failures,omitempty"`
+	// When the run stopped scheduling new checks, if it had a deadline
+	Deadline time.Time `json:"deadline,omitzero"`
+	// True if the run was cut short by a shutdown, so some checks never started
+	Interrupted bool `json:"interrupted,omitempty"`
+
+	mu      sync.Mutex
+	stopped string
+}
+
+func (r *Report) addWorkspace(res *WorkspaceResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Workspaces = append(r.Workspaces, res)
+}
+
+func (r *Report) addDirectory(res *DirectoryResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Directories = append(r.Directories, res)
+}
+
+// reportedWorkspace identifies a workspace check in a report
+type reportedWorkspace struct {
+	dir       string
+	workspace string
+	project   string
+}
+
+// reported returns the workspaces and directories the report has a result for
+func (r *Report) reported() (map[reportedWorkspace]bool, map[string]bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	workspaces := make(map[reportedWorkspace]bool, len(r.Workspaces))
+	for _, w := range r.Workspaces {
+		workspaces[reportedWorkspace{dir: w.Dir, workspace: w.Workspace, project: w.ProjectName}] = true
+	}
+	dirs := make(map[string]bool, len(r.Directories))
+	for _, dir := range r.Directories {
+		dirs[dir.Dir] = true
+	}
+	return workspaces, dirs
+}
+
+func (r *Report) addFailure(f *Failure) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Failures = append(r.Failures, f)
+}
+
+func (r *Report) failures() []*Failure {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ret := make([]*Failure, len(r.Failures))
+	copy(ret, r.Failures)
+	return ret
+}
+
+// stopScheduling stops any new checks from starting, for reason
+func (r *Report) stopScheduling(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped == "" {
+		r.stopped = reason
+	}
+}
+
+// stopReason returns why no new checks should start, or an empty string if they still can
+func (r *Report) stopReason() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped != "" {
+		return r.stopped
+	}
+	if !r.Deadline.IsZero() && !time.Now().Before(r.Deadline) {
+		return deadlineReason
+	}
+	return ""
+}
+
+// finish sorts the report so that output is stable regardless of parallelism
+func (r *Report) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.End = time.Now()
+	sort.SliceStable(r.Workspaces, func(i, j int) bool {
+		if r.Workspaces[i].Dir != r.Workspaces[j].Dir {
+			return r.Workspaces[i].Dir < r.Workspaces[j].Dir
+		}
+		return r.Workspaces[i].Workspace < r.Workspaces[j].Workspace
+	})
+	sort.SliceStable(r.Directories, func(i, j int) bool {
+		return r.Directories[i].Dir < r.Directories[j].Dir
+	})
+}
+
+// CountOutcome returns how many workspace checks ended with outcome o
+func (r *Report) CountOutcome(o Outcome) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := 0
+	for _, w := range r.Workspaces {
+		if w.Outcome == o {
+			count++
+		}
+	}
+	return count
+}
+
+// WriteSummary writes one line per check in the report, with its outcome and why
+func (r *Report) WriteSummary(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "DIRECTORY\tWORKSPACE\tOUTCOME\tREASON"); err != nil {
+		return err
+	}
+	for _, ws := range r.Workspaces {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", ws.Dir, ws.Workspace, ws.Outcome, ws.Reason); err != nil {
+			return err
+		}
+	}
+	for _, dir := range r.Directories {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", dir.Dir, "(remote workspaces)", dir.Outcome, dir.Reason); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,79 @@
+package drifter
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/cresta/atlantis-drift-detection/internal/filter"
+	"github.com/cresta/atlantis-drift-detection/internal/processedcache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport_WriteSummary(t *testing.T) {
+	r := Report{}
+	r.addWorkspace(&WorkspaceResult{Dir: "b", Workspace: "prod", Outcome: OutcomeWouldPlan, Reason: "not in cache"})
+	r.addWorkspace(&WorkspaceResult{Dir: "a", Workspace: "dev", Outcome: OutcomeSkippedFilter, Reason: "excluded by a"})
+	r.addDirectory(&DirectoryResult{Dir: "b", Outcome: OutcomeWouldCheck, Reason: "cache expired"})
+	r.finish()
+	var buf bytes.Buffer
+	require.NoError(t, r.WriteSummary(&buf))
+	require.Equal(t, `DIRECTORY  WORKSPACE            OUTCOME         REASON
+a          dev                  skipped_filter  excluded by a
+b          prod                 would_plan      not in cache
+b          (remote workspaces)  would_check     cache expired
+`, buf.String())
+	require.Equal(t, 1, r.CountOutcome(OutcomeWouldPlan))
+}
+
+func TestDrifter_findDriftReport(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeAtlantis{}
+	fake.setBody(changesResult)
+	notif := &recordingNotification{}
+	d := testDrifter(t, fake, notif)
+	d.CacheValidDuration = time.Hour
+	var err error
+	d.DirectoryFilter, err = filter.New(nil, []string{"b"})
+	require.NoError(t, err)
+	require.NoError(t, d.ResultCache.StoreDriftCheckResult(ctx, &processedcache.ConsiderDriftChecked{Dir: "a", Workspace: "dev"}, &processedcache.DriftCheckValue{When: time.Now()}))
+	require.NoError(t, d.ResultCache.StoreRemoteWorkspaces(ctx, &processedcache.ConsiderWorkspacesChecked{Dir: "a"}, &processedcache.WorkspacesCheckedValue{When: time.Now()}))
+	ws := atlantis.DirectoriesWithWorkspaces{
+		"a": {{Name: "prod", ProjectName: "a-prod"}, {Name: "dev"}},
+		"b": {{Name: "prod"}},
+	}
+
+	report := &Report{}
+	require.NoError(t, d.FindDriftedWorkspaces(ctx, ws, report))
+	require.NoError(t, d.FindExtraWorkspaces(ctx, ws, report))
+	report.finish()
+
+	require.Len(t, report.Workspaces, 3)
+	prod := report.Workspaces[1]
+	require.Equal(t, "a", prod.Dir)
+	require.Equal(t, "prod", prod.Workspace)
+	require.Equal(t, "a-prod", prod.ProjectName)
+	require.Equal(t, OutcomeDrift, prod.Outcome)
+	require.True(t, prod.Notified)
+	require.Equal(t, []string{"Plan: 1 to add, 0 to change, 0 to destroy."}, prod.PlanSummaries)
+	require.False(t, prod.Start.IsZero())
+	require.False(t, prod.End.Before(prod.Start))
+	require.Equal(t, []string{"PlanDrift a#prod"}, notif.take())
+	require.Len(t, report.Directories, 2)
+	require.Equal(t, 1, report.CountOutcome(OutcomeDrift))
+	require.Equal(t, 1, report.CountOutcome(OutcomeSkippedCache))
+	require.Equal(t, 1, report.CountOutcome(OutcomeSkippedFilter))
+	require.Empty(t, report.Failures)
+
+	var buf bytes.Buffer
+	require.NoError(t, report.WriteSummary(&buf))
+	require.Equal(t, `DIRECTORY  WORKSPACE            OUTCOME         REASON
+a          dev                  skipped_cache   checked 0s ago, cache valid for 1h0m0s
+a          prod                 drift           
+b          prod                 skipped_filter  excluded by b
+a          (remote workspaces)  skipped_cache   checked 0s ago, cache valid for 1h0m0s
+b          (remote workspaces)  skipped_filter  excluded by b
+`, buf.String())
+}
@@ -0,0 +1,80 @@
+package drifter
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"go.uber.org/zap"
+)
+
+// retryBackoff returns how long to wait after a failed attempt (starting at 1).  The delay doubles each attempt, is
+// capped at maxBackoff, and is jittered to somewhere between half and all of that value so parallel runs spread out.
+func retryBackoff(attempt int, initial time.Duration, maxBackoff time.Duration, jitter func(n int64) int64) time.Duration {
+	backoff := exponentialBackoff(attempt, initial, maxBackoff)
+	if backoff <= 0 {
+		return 0
+	}
+	half := int64(backoff / 2)
+	return time.Duration(half + jitter(half+1))
+}
+
+// exponentialBackoff returns initial, doubled for every attempt after the first and capped at maxBackoff.  Without a
+// positive maxBackoff it stops growing at the longest time.Duration instead of overflowing.
+func exponentialBackoff(attempt int, initial time.Duration, maxBackoff time.Duration) time.Duration {
+	if initial <= 0 {
+		return 0
+	}
+	backoff := initial
+	for i := 1; i < attempt && (maxBackoff <= 0 || backoff < maxBackoff); i++ {
+		if backoff > math.MaxInt64/2 {
+			backoff = math.MaxInt64
+			break
+		}
+		backoff *= 2
+	}
+	if maxBackoff > 0 && backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+func isTemporary(err error) bool {
+	var tmp atlantis.TemporaryError
+	return errors.As(err, &tmp) && tmp.Temporary()
+}
+
+func planResultLabel(err error) string {
+	if err == nil {
+		return "success"
+	}
+	if isTemporary(err) {
+		return "temporary_error"
+	}
+	return "error"
+}
+
+// planSummary asks atlantis for a plan, retrying temporary errors up to PlanMaxAttempts times
+func (d *Drifter) planSummary(ctx context.Context, req *atlantis.PlanSummaryRequest) (*atlantis.PlanResult, error) {
+	for attempt := 1; ; attempt++ {
+		planStart := time.Now()
+		pr, err := d.AtlantisClient.PlanSummary(ctx, req)
+		d.Metrics.ObservePlan(planResultLabel(err), time.Since(planStart))
+		if err == nil {
+			return pr, nil
+		}
+		if !isTemporary(err) || attempt >= d.PlanMaxAttempts {
+			return nil, err
+		}
+		wait := retryBackoff(attempt, d.PlanRetryBackoff, d.PlanRetryMaxBackoff, rand.Int64N)
+		d.Logger.Warn("Temporary error, retrying plan", zap.String("dir", req.Dir), zap.String("workspace", req.Workspace), zap.Int("attempt", attempt), zap.Duration("backoff", wait), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
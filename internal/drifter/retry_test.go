@@ -0,0 +1,95 @@
+package drifter
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	noJitter := func(n int64) int64 { return n - 1 }
+	fullJitter := func(n int64) int64 { return 0 }
+	require.Equal(t, time.Second, retryBackoff(1, time.Second, time.Minute, noJitter))
+	require.Equal(t, 2*time.Second, retryBackoff(2, time.Second, time.Minute, noJitter))
+	require.Equal(t, 8*time.Second, retryBackoff(4, time.Second, time.Minute, noJitter))
+	require.Equal(t, time.Minute, retryBackoff(20, time.Second, time.Minute, noJitter))
+	require.Equal(t, 4*time.Second, retryBackoff(4, time.Second, time.Minute, fullJitter))
+	require.Equal(t, time.Duration(0), retryBackoff(3, 0, time.Minute, noJitter))
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	require.Equal(t, 4*time.Hour, exponentialBackoff(3, time.Hour, 0))
+	require.Equal(t, 3*time.Hour, exponentialBackoff(3, time.Hour, 3*time.Hour))
+	for _, attempt := range []int{30, 64, 1000} {
+		require.Equal(t, time.Duration(math.MaxInt64), exponentialBackoff(attempt, time.Hour, 0), "attempt %d", attempt)
+	}
+	require.Equal(t, 24*time.Hour, exponentialBackoff(1000, time.Hour, 24*time.Hour))
+}
+
+// flakyAtlantis answers the first failures plan requests with a temporary error, and every later one with noChangesResult
+func flakyAtlantis(t *testing.T, failures int32) (*atlantis.Client, *atomic.Int32) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("overloaded"))
+			return
+		}
+		_, _ = w.Write([]byte(noChangesResult))
+	}))
+	t.Cleanup(srv.Close)
+	return &atlantis.Client{
+		AtlantisHostname: srv.URL,
+		HTTPClient:       srv.Client(),
+	}, &requests
+}
+
+func TestDrifter_planSummaryRetries(t *testing.T) {
+	notif := &recordingNotification{}
+	d := testDrifter(t, &fakeAtlantis{}, notif)
+	d.PlanMaxAttempts = 3
+	d.PlanRetryBackoff = time.Millisecond
+	var requests *atomic.Int32
+	d.AtlantisClient, requests = flakyAtlantis(t, 2)
+	var res WorkspaceResult
+	require.NoError(t, d.checkWorkspace(context.Background(), workspaceCheck{dir: "dir", workspace: atlantis.Workspace{Name: "prod"}}, &res))
+	require.Equal(t, OutcomeNoDrift, res.Outcome)
+	require.Equal(t, int32(3), requests.Load())
+	require.Empty(t, notif.take())
+}
+
+func TestDrifter_planSummaryGivesUp(t *testing.T) {
+	notif := &recordingNotification{}
+	d := testDrifter(t, &fakeAtlantis{}, notif)
+	d.PlanMaxAttempts = 2
+	d.PlanRetryBackoff = time.Millisecond
+	var requests *atomic.Int32
+	d.AtlantisClient, requests = flakyAtlantis(t, 10)
+	var res WorkspaceResult
+	require.NoError(t, d.checkWorkspace(context.Background(), workspaceCheck{dir: "dir", workspace: atlantis.Workspace{Name: "prod"}}, &res))
+	require.Equal(t, OutcomeTemporaryError, res.Outcome)
+	require.Equal(t, int32(2), requests.Load())
+	require.Equal(t, []string{"TemporaryError dir#prod"}, notif.take())
+}
+
+func TestDrifter_planSummaryCancelledWhileWaiting(t *testing.T) {
+	d := testDrifter(t, &fakeAtlantis{}, &recordingNotification{})
+	d.PlanMaxAttempts = 5
+	d.PlanRetryBackoff = time.Hour
+	var requests *atomic.Int32
+	d.AtlantisClient, requests = flakyAtlantis(t, 10)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := d.planSummary(ctx, &atlantis.PlanSummaryRequest{Dir: "dir", Workspace: "prod"})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Minute)
+	require.Equal(t, int32(1), requests.Load())
+}
@@ -0,0 +1,36 @@
+package drifter
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// Shard splits work deterministically across Count jobs that each run with a different Index.  The zero value owns
+// everything.
+type Shard struct {
+	Index int
+	Count int
+}
+
+func (s Shard) Validate() error {
+	if s.Count < 0 {
+		return fmt.Errorf("shard count %d cannot be negative", s.Count)
+	}
+	if s.Count > 0 && (s.Index < 0 || s.Index >= s.Count) {
+		return fmt.Errorf("shard index %d must be between 0 and %d", s.Index, s.Count-1)
+	}
+	return nil
+}
+
+// Owns returns true if this shard should check dir/workspace.  Pass an empty workspace for directory level checks.
+func (s Shard) Owns(dir string, workspace string) bool {
+	if s.Count <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	// Writes to a hash never fail
+	_, _ = h.Write([]byte(dir))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(workspace))
+	return int(h.Sum32()%uint32(s.Count)) == s.Index
+}
@@ -0,0 +1,68 @@
+package drifter
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cresta/atlantis-drift-detection/internal/atlantis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShard_Owns(t *testing.T) {
+	const count = 4
+	owned := make([]int, count)
+	for i := 0; i < 1000; i++ {
+		dir := fmt.Sprintf("environments/aws/dir%d", i)
+		owners := 0
+		for idx := 0; idx < count; idx++ {
+			s := Shard{Index: idx, Count: count}
+			if s.Owns(dir, "prod") {
+				owners++
+				owned[idx]++
+			}
+			require.Equal(t, s.Owns(dir, "prod"), s.Owns(dir, "prod"))
+		}
+		require.Equal(t, 1, owners, "every dir/workspace is owned by exactly one shard")
+	}
+	for _, o := range owned {
+		require.Greater(t, o, 150, "work is spread across shards")
+	}
+	require.True(t, Shard{}.Owns("anything", ""))
+}
+
+func TestShard_Validate(t *testing.T) {
+	require.NoError(t, Shard{}.Validate())
+	require.NoError(t, Shard{Index: 2, Count: 3}.Validate())
+	require.Error(t, Shard{Index: 3, Count: 3}.Validate())
+	require.Error(t, Shard{Index: -1, Count: 3}.Validate())
+	require.Error(t, Shard{Count: -1}.Validate())
+}
+
+func TestDrifter_FindDriftedWorkspacesSharded(t *testing.T) {
+	ws := atlantis.DirectoriesWithWorkspaces{}
+	for i := 0; i < 20; i++ {
+		ws[fmt.Sprintf("dir%d", i)] = []atlantis.Workspace{{Name: "dev"}, {Name: "prod"}}
+	}
+	for _, unit := range []ParallelUnit{ParallelDirectory, ParallelWorkspace} {
+		reported := map[string]int{}
+		for idx := 0; idx < 2; idx++ {
+			d := testDrifter(t, &fakeAtlantis{}, &recordingNotification{})
+			d.ParallelUnit = unit
+			d.Shard = Shard{Index: idx, Count: 2}
+			// Every directory is filtered out, so no workspace is planned, but every one is still reported
+			d.DirectoryWhitelist = []string{"other"}
+			var report Report
+			require.NoError(t, d.FindDriftedWorkspaces(context.Background(), ws, &report))
+			for _, res := range report.Workspaces {
+				require.Equal(t, OutcomeSkippedFilter, res.Outcome)
+				require.True(t, d.Shard.Owns(res.Dir, res.Workspace))
+				reported[res.Dir+"#"+res.Workspace]++
+			}
+		}
+		require.Len(t, reported, 40, string(unit))
+		for loc, n := range reported {
+			require.Equal(t, 1, n, "%s reported by every shard with %s", loc, unit)
+		}
+	}
+}
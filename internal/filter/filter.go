@@ -0,0 +1,93 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// RegexPrefix marks a pattern as a regular expression rather than a glob
+const RegexPrefix = "regex:"
+
+// Rule is a single pattern.  Patterns starting with RegexPrefix are regular expressions, everything else is a
+// doublestar glob (for example environments/aws/**).
+type Rule struct {
+	Pattern string
+	re      *regexp.Regexp
+}
+
+func NewRule(pattern string) (*Rule, error) {
+	if expr, ok := strings.CutPrefix(pattern, RegexPrefix); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %s: %w", pattern, err)
+		}
+		return &Rule{Pattern: pattern, re: re}, nil
+	}
+	if !doublestar.ValidatePattern(pattern) {
+		return nil, fmt.Errorf("invalid glob %s", pattern)
+	}
+	return &Rule{Pattern: pattern}, nil
+}
+
+func (r *Rule) Matches(value string) bool {
+	if r.re != nil {
+		return r.re.MatchString(value)
+	}
+	// Pattern is validated in NewRule, so the only error possible is ErrBadPattern
+	ok, _ := doublestar.Match(r.Pattern, value)
+	return ok
+}
+
+func (r *Rule) String() string {
+	return r.Pattern
+}
+
+// Filter decides which values to check.  A value is skipped if any exclude rule matches it, or if there are include
+// rules and none of them match it.  A nil Filter allows everything.
+type Filter struct {
+	Include []*Rule
+	Exclude []*Rule
+}
+
+func New(include []string, exclude []string) (*Filter, error) {
+	var ret Filter
+	for _, p := range include {
+		r, err := NewRule(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern: %w", err)
+		}
+		ret.Include = append(ret.Include, r)
+	}
+	for _, p := range exclude {
+		r, err := NewRule(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern: %w", err)
+		}
+		ret.Exclude = append(ret.Exclude, r)
+	}
+	return &ret, nil
+}
+
+// Allows returns true if value should be checked, along with a description of the rule that decided it
+func (f *Filter) Allows(value string) (bool, string) {
+	if f == nil {
+		return true, ""
+	}
+	for _, r := range f.Exclude {
+		if r.Matches(value) {
+			return false, "excluded by " + r.Pattern
+		}
+	}
+	if len(f.Include) == 0 {
+		return true, ""
+	}
+	for _, r := range f.Include {
+		if r.Matches(value) {
+			return true, "included by " + r.Pattern
+		}
+	}
+	return false, "not matched by any include pattern"
+}
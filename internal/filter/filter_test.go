@@ -0,0 +1,42 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter_Allows(t *testing.T) {
+	f, err := New([]string{"environments/aws/**"}, []string{"**/sandbox", "regex:^environments/aws/tmp-[0-9]+$"})
+	require.NoError(t, err)
+	run := []struct {
+		value  string
+		allows bool
+		reason string
+	}{
+		{value: "environments/aws/account/datadog", allows: true, reason: "included by environments/aws/**"},
+		{value: "environments/aws/sandbox", allows: false, reason: "excluded by **/sandbox"},
+		{value: "environments/aws/tmp-123", allows: false, reason: "excluded by regex:^environments/aws/tmp-[0-9]+$"},
+		{value: "environments/gcp/example", allows: false, reason: "not matched by any include pattern"},
+	}
+	for _, r := range run {
+		t.Run(r.value, func(t *testing.T) {
+			allows, reason := f.Allows(r.value)
+			require.Equal(t, r.allows, allows)
+			require.Equal(t, r.reason, reason)
+		})
+	}
+}
+
+func TestFilter_Nil(t *testing.T) {
+	var f *Filter
+	allows, _ := f.Allows("anything")
+	require.True(t, allows)
+}
+
+func TestNew_Invalid(t *testing.T) {
+	_, err := New([]string{"regex:("}, nil)
+	require.Error(t, err)
+	_, err = New(nil, []string{"[abc"})
+	require.Error(t, err)
+}
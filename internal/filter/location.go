@@ -0,0 +1,67 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LocationSeparator splits the directory and workspace parts of a LocationRule pattern
+const LocationSeparator = "#"
+
+// LocationRule matches a directory/workspace pair.  Its pattern is either a workspace pattern, which matches in every
+// directory, or a directory pattern and a workspace pattern joined by LocationSeparator (for example
+// environments/aws/**#scratch-*).  Terraform workspace names cannot contain LocationSeparator, so neither can the
+// workspace pattern: the last separator always splits the pattern, and a directory pattern, regex or not, may use it.
+type LocationRule struct {
+	Pattern string
+	// Nil matches every directory
+	Directory *Rule
+	Workspace *Rule
+}
+
+func NewLocationRule(pattern string) (*LocationRule, error) {
+	ret := &LocationRule{Pattern: pattern}
+	workspace := pattern
+	if idx := strings.LastIndex(pattern, LocationSeparator); idx >= 0 {
+		dir, ws := pattern[:idx], pattern[idx+len(LocationSeparator):]
+		if dir == "" || ws == "" {
+			return nil, fmt.Errorf("invalid pattern %s: needs both a directory and a workspace pattern around %s", pattern, LocationSeparator)
+		}
+		r, err := NewRule(dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid directory pattern in %s: %w", pattern, err)
+		}
+		ret.Directory = r
+		workspace = ws
+	}
+	r, err := NewRule(workspace)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workspace pattern in %s: %w", pattern, err)
+	}
+	ret.Workspace = r
+	return ret, nil
+}
+
+// NewLocationRules parses every pattern with NewLocationRule
+func NewLocationRules(patterns []string) ([]*LocationRule, error) {
+	ret := make([]*LocationRule, 0, len(patterns))
+	for _, p := range patterns {
+		r, err := NewLocationRule(p)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+func (r *LocationRule) Matches(dir string, workspace string) bool {
+	if r.Directory != nil && !r.Directory.Matches(dir) {
+		return false
+	}
+	return r.Workspace.Matches(workspace)
+}
+
+func (r *LocationRule) String() string {
+	return r.Pattern
+}
@@ -0,0 +1,36 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocationRule_Matches(t *testing.T) {
+	rules, err := NewLocationRules([]string{"scratch-*", "environments/aws/**#regex:^tmp-[0-9]+$"})
+	require.NoError(t, err)
+	require.Nil(t, rules[0].Directory)
+	require.True(t, rules[0].Matches("anywhere", "scratch-1"))
+	require.False(t, rules[0].Matches("anywhere", "prod"))
+	require.True(t, rules[1].Matches("environments/aws/account", "tmp-123"))
+	require.False(t, rules[1].Matches("environments/gcp/account", "tmp-123"))
+	require.False(t, rules[1].Matches("environments/aws/account", "tmp-abc"))
+
+	// Only the last separator splits, so a directory regex can use it
+	r, err := NewLocationRule("regex:^environments/[^#]+/c#1$#prod")
+	require.NoError(t, err)
+	require.True(t, r.Matches("environments/aws/c#1", "prod"))
+	require.False(t, r.Matches("environments/aws/c#1", "dev"))
+	require.False(t, r.Matches("environments/aws/c", "prod"))
+}
+
+func TestNewLocationRule_Invalid(t *testing.T) {
+	_, err := NewLocationRule("[abc#prod")
+	require.Error(t, err)
+	_, err = NewLocationRule("environments/**#regex:(")
+	require.Error(t, err)
+	_, err = NewLocationRule("regex:^scratch-[^#]+#")
+	require.Error(t, err)
+	_, err = NewLocationRule("#prod")
+	require.Error(t, err)
+}
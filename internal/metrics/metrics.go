@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "atlantis_drift_detection"
+
+// DriftStates are every value the drift_state gauge can report for a directory/workspace
+var DriftStates = []string{StateDrifted, StateClean, StateLocked, StateError}
+
+const (
+	StateDrifted = "drifted"
+	StateClean   = "clean"
+	StateLocked  = "locked"
+	StateError   = "error"
+)
+
+const (
+	CheckDrift      = "drift"
+	CheckWorkspaces = "workspaces"
+)
+
+// Metrics are the prometheus metrics for drift detection.  A nil *Metrics records nothing.
+type Metrics struct {
+	DriftState   *prometheus.GaugeVec
+	PlanCalls    *prometheus.CounterVec
+	PlanLatency  prometheus.Histogram
+	InitDuration prometheus.Histogram
+	CacheLookups *prometheus.GaugeVec
+}
+
+func New(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		DriftState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "drift_state",
+			Help:      "1 for the current drift state of a directory/workspace, 0 for every other state",
+		}, []string{"dir", "workspace", "state"}),
+		PlanCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "atlantis_plan_calls_total",
+			Help:      "Atlantis plan API calls, by result",
+		}, []string{"result"}),
+		PlanLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "atlantis_plan_duration_seconds",
+			Help:      "How long atlantis takes to return a plan summary",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+		}),
+		InitDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "terraform_init_duration_seconds",
+			Help:      "How long terraform init takes for a directory",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+		}),
+		CacheLookups: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "cache_lookups",
+			Help:      "Cache hits and misses during the most recent run, by check",
+		}, []string{"check", "result"}),
+	}
+	reg.MustRegister(m.DriftState, m.PlanCalls, m.PlanLatency, m.InitDuration, m.CacheLookups)
+	return m
+}
+
+// SetDriftState marks state as the current state of dir/workspace
+func (m *Metrics) SetDriftState(dir string, workspace string, state string) {
+	if m == nil {
+		return
+	}
+	for _, s := range DriftStates {
+		val := 0.0
+		if s == state {
+			val = 1
+		}
+		m.DriftState.WithLabelValues(dir, workspace, s).Set(val)
+	}
+}
+
+func (m *Metrics) ObservePlan(result string, took time.Duration) {
+	if m == nil {
+		return
+	}
+	m.PlanCalls.WithLabelValues(result).Inc()
+	m.PlanLatency.Observe(took.Seconds())
+}
+
+func (m *Metrics) ObserveInit(took time.Duration) {
+	if m == nil {
+		return
+	}
+	m.InitDuration.Observe(took.Seconds())
+}
+
+// ResetCacheLookups zeroes the cache gauges at the start of a run
+func (m *Metrics) ResetCacheLookups() {
+	if m == nil {
+		return
+	}
+	for _, check := range []string{CheckDrift, CheckWorkspaces} {
+		m.CacheLookups.WithLabelValues(check, "hit").Set(0)
+		m.CacheLookups.WithLabelValues(check, "miss").Set(0)
+	}
+}
+
+func (m *Metrics) CacheLookup(check string, hit bool) {
+	if m == nil {
+		return
+	}
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.CacheLookups.WithLabelValues(check, result).Inc()
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New(prometheus.NewRegistry())
+	m.SetDriftState("dir", "ws", StateClean)
+	m.SetDriftState("dir", "ws", StateDrifted)
+	require.Equal(t, 1.0, testutil.ToFloat64(m.DriftState.WithLabelValues("dir", "ws", StateDrifted)))
+	require.Equal(t, 0.0, testutil.ToFloat64(m.DriftState.WithLabelValues("dir", "ws", StateClean)))
+
+	m.ObservePlan("success", time.Second)
+	m.ObservePlan("success", time.Second)
+	require.Equal(t, 2.0, testutil.ToFloat64(m.PlanCalls.WithLabelValues("success")))
+
+	m.ResetCacheLookups()
+	m.CacheLookup(CheckDrift, true)
+	m.CacheLookup(CheckDrift, false)
+	m.CacheLookup(CheckDrift, false)
+	require.Equal(t, 1.0, testutil.ToFloat64(m.CacheLookups.WithLabelValues(CheckDrift, "hit")))
+	require.Equal(t, 2.0, testutil.ToFloat64(m.CacheLookups.WithLabelValues(CheckDrift, "miss")))
+	m.ResetCacheLookups()
+	require.Equal(t, 0.0, testutil.ToFloat64(m.CacheLookups.WithLabelValues(CheckDrift, "miss")))
+}
+
+func TestMetrics_Nil(t *testing.T) {
+	var m *Metrics
+	m.SetDriftState("dir", "ws", StateClean)
+	m.ObservePlan("success", time.Second)
+	m.ObserveInit(time.Second)
+	m.ResetCacheLookups()
+	m.CacheLookup(CheckDrift, true)
+}
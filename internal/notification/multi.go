@@ -1,45 +1,78 @@
 package notification
 
-import "context"
+import (
+	"context"
+	"fmt"
+
+	"github.com/cresta/atlantis-drift-detection/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/cresta/atlantis-drift-detection/internal/notification"
 
 type Multi struct {
 	Notifications []Notification
 }
 
-func (m *Multi) TemporaryError(ctx context.Context, dir string, workspace string, err error) error {
+// each calls f on every notification, inside a span per notification
+func (m *Multi) each(ctx context.Context, name string, loc Location, f func(ctx context.Context, n Notification) error) error {
 	for _, n := range m.Notifications {
-		if err := n.TemporaryError(ctx, dir, workspace, err); err != nil {
+		spanCtx, span := tracing.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(
+			tracing.DirKey.String(loc.Directory),
+			tracing.WorkspaceKey.String(loc.Workspace),
+			attribute.String("notification.type", fmt.Sprintf("%T", n)),
+		))
+		err := f(spanCtx, n)
+		tracing.RecordError(span, err)
+		span.End()
+		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (m *Multi) ExtraWorkspaceInRemote(ctx context.Context, dir string, workspace string) error {
-	for _, n := range m.Notifications {
-		if err := n.ExtraWorkspaceInRemote(ctx, dir, workspace); err != nil {
-			return err
-		}
-	}
-	return nil
+func (m *Multi) TemporaryError(ctx context.Context, loc Location, err error) error {
+	return m.each(ctx, "TemporaryError", loc, func(ctx context.Context, n Notification) error {
+		return n.TemporaryError(ctx, loc, err)
+	})
 }
 
-func (m *Multi) MissingWorkspaceInRemote(ctx context.Context, dir string, workspace string) error {
-	for _, n := range m.Notifications {
-		if err := n.MissingWorkspaceInRemote(ctx, dir, workspace); err != nil {
-			return err
-		}
-	}
-	return nil
+func (m *Multi) ExtraWorkspaceInRemote(ctx context.Context, loc Location) error {
+	return m.each(ctx, "ExtraWorkspaceInRemote", loc, func(ctx context.Context, n Notification) error {
+		return n.ExtraWorkspaceInRemote(ctx, loc)
+	})
 }
 
-func (m *Multi) PlanDrift(ctx context.Context, dir string, workspace string) error {
-	for _, n := range m.Notifications {
-		if err := n.PlanDrift(ctx, dir, workspace); err != nil {
-			return err
-		}
-	}
-	return nil
+func (m *Multi) MissingWorkspaceInRemote(ctx context.Context, loc Location) error {
+	return m.each(ctx, "MissingWorkspaceInRemote", loc, func(ctx context.Context, n Notification) error {
+		return n.MissingWorkspaceInRemote(ctx, loc)
+	})
+}
+
+func (m *Multi) PlanDrift(ctx context.Context, loc Location, changes PlanChanges) error {
+	return m.each(ctx, "PlanDrift", loc, func(ctx context.Context, n Notification) error {
+		return n.PlanDrift(ctx, loc, changes)
+	})
+}
+
+func (m *Multi) DriftResolved(ctx context.Context, loc Location) error {
+	return m.each(ctx, "DriftResolved", loc, func(ctx context.Context, n Notification) error {
+		return n.DriftResolved(ctx, loc)
+	})
+}
+
+func (m *Multi) StaleLock(ctx context.Context, loc Location, lock Lock) error {
+	return m.each(ctx, "StaleLock", loc, func(ctx context.Context, n Notification) error {
+		return n.StaleLock(ctx, loc, lock)
+	})
+}
+
+func (m *Multi) PersistentFailure(ctx context.Context, loc Location, failures int, err error) error {
+	return m.each(ctx, "PersistentFailure", loc, func(ctx context.Context, n Notification) error {
+		return n.PersistentFailure(ctx, loc, failures, err)
+	})
 }
 
 var _ Notification = &Multi{}
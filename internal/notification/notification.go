@@ -2,6 +2,9 @@ package notification
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 )
 
 type State int
@@ -16,12 +19,90 @@ const (
 type Location struct {
 	Directory string
 	Workspace string
+	// ProjectName is the atlantis project name, if atlantis.yaml gives the project one
+	ProjectName string
+}
+
+// Lock is an atlantis lock held by a pull request
+type Lock struct {
+	PullID  int    `json:"pull_id,omitempty"`
+	PullURL string `json:"pull_url,omitempty"`
+	User    string `json:"user,omitempty"`
+	// When the lock was taken, or when drift detection first saw it if atlantis does not say
+	Since time.Time `json:"since"`
+}
+
+func (l Lock) String() string {
+	ret := "unknown pull request"
+	if l.PullID != 0 {
+		ret = fmt.Sprintf("pull #%d", l.PullID)
+	}
+	if l.PullURL != "" {
+		ret += " (" + l.PullURL + ")"
+	}
+	if l.User != "" {
+		ret += " by " + l.User
+	}
+	return fmt.Sprintf("%s, locked for %s", ret, time.Since(l.Since).Round(time.Minute))
+}
+
+// PlanChanges is what a drifted plan would change
+type PlanChanges struct {
+	Import  int `json:"import,omitempty"`
+	Add     int `json:"add,omitempty"`
+	Change  int `json:"change,omitempty"`
+	Destroy int `json:"destroy,omitempty"`
+	// True if terraform noticed objects that changed outside of Terraform since they were last applied
+	ChangesOutside bool `json:"changes_outside,omitempty"`
+	// Every resource the plan would change
+	Resources []ResourceChange `json:"resources,omitempty"`
+}
+
+// ResourceChange is one resource a drifted plan would change
+type ResourceChange struct {
+	Address string `json:"address"`
+	// What the plan would do: create, update, replace, delete, read, import or move
+	Action string `json:"action"`
+}
+
+func (r ResourceChange) String() string {
+	return r.Action + " " + r.Address
+}
+
+func (c PlanChanges) String() string {
+	var parts []string
+	if c.Import > 0 {
+		parts = append(parts, fmt.Sprintf("%d to import", c.Import))
+	}
+	if c.Add > 0 {
+		parts = append(parts, fmt.Sprintf("%d to add", c.Add))
+	}
+	if c.Change > 0 {
+		parts = append(parts, fmt.Sprintf("%d to change", c.Change))
+	}
+	if c.Destroy > 0 {
+		parts = append(parts, fmt.Sprintf("%d to destroy", c.Destroy))
+	}
+	ret := strings.Join(parts, ", ")
+	if ret == "" {
+		ret = "unknown changes"
+	}
+	if c.ChangesOutside {
+		ret += " (objects have changed outside of Terraform)"
+	}
+	return ret
 }
 
 type Notification interface {
-	ExtraWorkspaceInRemote(ctx context.Context, dir string, workspace string) error
-	MissingWorkspaceInRemote(ctx context.Context, dir string, workspace string) error
-	PlanDrift(ctx context.Context, dir string, workspace string) error
+	ExtraWorkspaceInRemote(ctx context.Context, loc Location) error
+	MissingWorkspaceInRemote(ctx context.Context, loc Location) error
+	PlanDrift(ctx context.Context, loc Location, changes PlanChanges) error
+	// DriftResolved is called when a workspace that had drifted the last time it was checked no longer has drift
+	DriftResolved(ctx context.Context, loc Location) error
 	// TemporaryError is called when an error occurs but we can't really tell what it means
-	TemporaryError(ctx context.Context, dir string, workspace string, err error) error
+	TemporaryError(ctx context.Context, loc Location, err error) error
+	// StaleLock is called when a workspace has been locked by a pull request for longer than expected
+	StaleLock(ctx context.Context, loc Location, lock Lock) error
+	// PersistentFailure is called when checking a workspace has failed failures times in a row.  err is the last failure.
+	PersistentFailure(ctx context.Context, loc Location, failures int, err error) error
 }
@@ -2,13 +2,24 @@ package notification
 
 import (
 	"context"
+	"errors"
 	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 )
 
 func genericNotificationTest(t *testing.T, notification Notification) {
 	ctx := context.Background()
-	require.NoError(t, notification.ExtraWorkspaceInRemote(ctx, "genericNotificationTest/ExtraWorkspaceInRemote", "test-workspace"))
-	require.NoError(t, notification.MissingWorkspaceInRemote(ctx, "genericNotificationTest/MissingWorkspaceInRemote", "test-workspace"))
-	require.NoError(t, notification.PlanDrift(ctx, "genericNotificationTest/PlanDrift", "test-workspace"))
+	require.NoError(t, notification.ExtraWorkspaceInRemote(ctx, Location{Directory: "genericNotificationTest/ExtraWorkspaceInRemote", Workspace: "test-workspace"}))
+	require.NoError(t, notification.MissingWorkspaceInRemote(ctx, Location{Directory: "genericNotificationTest/MissingWorkspaceInRemote", Workspace: "test-workspace"}))
+	require.NoError(t, notification.PlanDrift(ctx, Location{Directory: "genericNotificationTest/PlanDrift", Workspace: "test-workspace", ProjectName: "test-project"}, PlanChanges{Add: 2, Destroy: 1}))
+	require.NoError(t, notification.StaleLock(ctx, Location{Directory: "genericNotificationTest/StaleLock", Workspace: "test-workspace"}, Lock{PullID: 123, Since: time.Now().Add(-72 * time.Hour)}))
+	require.NoError(t, notification.PersistentFailure(ctx, Location{Directory: "genericNotificationTest/PersistentFailure", Workspace: "test-workspace"}, 3, errors.New("plan failed")))
+	require.NoError(t, notification.DriftResolved(ctx, Location{Directory: "genericNotificationTest/DriftResolved", Workspace: "test-workspace"}))
+}
+
+func TestPlanChanges_String(t *testing.T) {
+	require.Equal(t, "2 to add, 1 to destroy", PlanChanges{Add: 2, Destroy: 1}.String())
+	require.Equal(t, "1 to import, 3 to change (objects have changed outside of Terraform)", PlanChanges{Import: 1, Change: 3, ChangesOutside: true}.String())
+	require.Equal(t, "unknown changes", PlanChanges{}.String())
 }
@@ -13,8 +13,8 @@ type SlackWebhook struct {
 	HTTPClient *http.Client
 }
 
-func (s *SlackWebhook) TemporaryError(ctx context.Context, dir string, workspace string, err error) error {
-	return s.sendSlackMessage(ctx, fmt.Sprintf("Unknown error in remote\nDirectory: %s\nWorkspace: %s\nError: %s", dir, workspace, err.Error()))
+func (s *SlackWebhook) TemporaryError(ctx context.Context, loc Location, err error) error {
+	return s.sendSlackMessage(ctx, fmt.Sprintf("Unknown error in remote\n%s\nError: %s", slackLocation(loc), err.Error()))
 }
 
 func NewSlackWebhook(webhookURL string, HTTPClient *http.Client) *SlackWebhook {
@@ -54,16 +54,51 @@ func (s *SlackWebhook) sendSlackMessage(ctx context.Context, msg string) error {
 	return nil
 }
 
-func (s *SlackWebhook) ExtraWorkspaceInRemote(ctx context.Context, dir string, workspace string) error {
-	return s.sendSlackMessage(ctx, fmt.Sprintf("Extra workspace in remote\nDirectory: %s\nWorkspace: %s", dir, workspace))
+func (s *SlackWebhook) ExtraWorkspaceInRemote(ctx context.Context, loc Location) error {
+	return s.sendSlackMessage(ctx, "Extra workspace in remote\n"+slackLocation(loc))
 }
 
-func (s *SlackWebhook) MissingWorkspaceInRemote(ctx context.Context, dir string, workspace string) error {
-	return s.sendSlackMessage(ctx, fmt.Sprintf("Missing workspace in remote\nDirectory: %s\nWorkspace: %s", dir, workspace))
+func (s *SlackWebhook) MissingWorkspaceInRemote(ctx context.Context, loc Location) error {
+	return s.sendSlackMessage(ctx, "Missing workspace in remote\n"+slackLocation(loc))
 }
 
-func (s *SlackWebhook) PlanDrift(ctx context.Context, dir string, workspace string) error {
-	return s.sendSlackMessage(ctx, fmt.Sprintf("Plan Drift workspace in remote\nDirectory: %s\nWorkspace: %s", dir, workspace))
+func (s *SlackWebhook) PlanDrift(ctx context.Context, loc Location, changes PlanChanges) error {
+	return s.sendSlackMessage(ctx, fmt.Sprintf("Plan Drift workspace in remote\n%s\nChanges: %s%s", slackLocation(loc), changes, slackResources(changes.Resources)))
+}
+
+// The most resources listed in one slack message, so a large drift does not flood the channel
+const maxSlackResources = 10
+
+func slackResources(resources []ResourceChange) string {
+	ret := ""
+	for i, r := range resources {
+		if i == maxSlackResources {
+			ret += fmt.Sprintf("\n• ... and %d more", len(resources)-maxSlackResources)
+			break
+		}
+		ret += "\n• " + r.String()
+	}
+	return ret
+}
+
+func (s *SlackWebhook) DriftResolved(ctx context.Context, loc Location) error {
+	return s.sendSlackMessage(ctx, "Plan Drift resolved in remote\n"+slackLocation(loc))
+}
+
+func (s *SlackWebhook) StaleLock(ctx context.Context, loc Location, lock Lock) error {
+	return s.sendSlackMessage(ctx, fmt.Sprintf("Stale atlantis lock hiding drift\n%s\nLock: %s", slackLocation(loc), lock))
+}
+
+func (s *SlackWebhook) PersistentFailure(ctx context.Context, loc Location, failures int, err error) error {
+	return s.sendSlackMessage(ctx, fmt.Sprintf("Drift check failed %d times in a row\n%s\nError: %s", failures, slackLocation(loc), err))
+}
+
+func slackLocation(loc Location) string {
+	ret := fmt.Sprintf("Directory: %s\nWorkspace: %s", loc.Directory, loc.Workspace)
+	if loc.ProjectName != "" {
+		ret += "\nProject: " + loc.ProjectName
+	}
+	return ret
 }
 
 var _ Notification = &SlackWebhook{}
@@ -1,8 +1,11 @@
 package notification
 
 import (
+	"fmt"
 	"github.com/cresta/atlantis-drift-detection/internal/testhelper"
+	"github.com/stretchr/testify/require"
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -11,3 +14,16 @@ func TestSlackWebhook_ExtraWorkspaceInRemote(t *testing.T) {
 	wh := NewSlackWebhook(testhelper.EnvOrSkip(t, "SLACK_WEBHOOK_URL"), http.DefaultClient)
 	genericNotificationTest(t, wh)
 }
+
+func TestSlackResources(t *testing.T) {
+	require.Equal(t, "", slackResources(nil))
+	require.Equal(t, "\n• create aws_instance.web", slackResources([]ResourceChange{{Address: "aws_instance.web", Action: "create"}}))
+	var many []ResourceChange
+	for i := 0; i < maxSlackResources+3; i++ {
+		many = append(many, ResourceChange{Address: fmt.Sprintf("aws_instance.web[%d]", i), Action: "update"})
+	}
+	msg := slackResources(many)
+	require.Contains(t, msg, "update aws_instance.web[9]")
+	require.NotContains(t, msg, "aws_instance.web[10]")
+	require.True(t, strings.HasSuffix(msg, "\n• ... and 3 more"))
+}
@@ -31,31 +31,43 @@ type Workflow struct {
 	directoriesDone map[string]struct{}
 }
 
-func (w *Workflow) TemporaryError(_ context.Context, _ string, _ string, _ error) error {
+func (w *Workflow) TemporaryError(_ context.Context, _ Location, _ error) error {
 	// Ignored
 	return nil
 }
 
-func (w *Workflow) ExtraWorkspaceInRemote(_ context.Context, _ string, _ string) error {
+func (w *Workflow) ExtraWorkspaceInRemote(_ context.Context, _ Location) error {
 	return nil
 }
 
-func (w *Workflow) MissingWorkspaceInRemote(_ context.Context, _ string, _ string) error {
+func (w *Workflow) MissingWorkspaceInRemote(_ context.Context, _ Location) error {
 	return nil
 }
 
-func (w *Workflow) PlanDrift(ctx context.Context, dir string, _ string) error {
+func (w *Workflow) DriftResolved(_ context.Context, _ Location) error {
+	return nil
+}
+
+func (w *Workflow) StaleLock(_ context.Context, _ Location, _ Lock) error {
+	return nil
+}
+
+func (w *Workflow) PersistentFailure(_ context.Context, _ Location, _ int, _ error) error {
+	return nil
+}
+
+func (w *Workflow) PlanDrift(ctx context.Context, loc Location, _ PlanChanges) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	if w.directoriesDone == nil {
 		w.directoriesDone = make(map[string]struct{})
 	}
-	if _, ok := w.directoriesDone[dir]; ok {
+	if _, ok := w.directoriesDone[loc.Directory]; ok {
 		return nil
 	}
-	w.directoriesDone[dir] = struct{}{}
+	w.directoriesDone[loc.Directory] = struct{}{}
 	return w.GhClient.TriggerWorkflow(ctx, w.WorkflowOwner, w.WorkflowRepo, w.WorkflowId, w.WorkflowRef, map[string]string{
-		"directory": dir,
+		"directory": loc.Directory,
 	})
 }
 
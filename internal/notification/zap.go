@@ -10,24 +10,47 @@ type Zap struct {
 	Logger *zap.Logger
 }
 
-func (I *Zap) TemporaryError(_ context.Context, dir string, workspace string, err error) error {
-	I.Logger.Error("Unknown error in remote", zap.String("dir", dir), zap.String("workspace", workspace), zap.Error(err))
+func (I *Zap) TemporaryError(_ context.Context, loc Location, err error) error {
+	I.Logger.Error("Unknown error in remote", append(zapLocation(loc), zap.Error(err))...)
 	return nil
 }
 
-func (I *Zap) PlanDrift(_ context.Context, dir string, workspace string) error {
-	I.Logger.Info("Plan has drifted", zap.String("dir", dir), zap.String("workspace", workspace))
+func (I *Zap) PlanDrift(_ context.Context, loc Location, changes PlanChanges) error {
+	I.Logger.Info("Plan has drifted", append(zapLocation(loc), zap.Int("import", changes.Import), zap.Int("add", changes.Add), zap.Int("change", changes.Change), zap.Int("destroy", changes.Destroy), zap.Bool("changes-outside", changes.ChangesOutside), zap.Stringers("resources", changes.Resources))...)
 	return nil
 }
 
-func (I *Zap) ExtraWorkspaceInRemote(_ context.Context, dir string, workspace string) error {
-	I.Logger.Info("Extra workspace in remote", zap.String("dir", dir), zap.String("workspace", workspace))
+func (I *Zap) DriftResolved(_ context.Context, loc Location) error {
+	I.Logger.Info("Plan drift resolved", zapLocation(loc)...)
 	return nil
 }
 
-func (I *Zap) MissingWorkspaceInRemote(_ context.Context, dir string, workspace string) error {
-	I.Logger.Info("Missing workspace in remote", zap.String("dir", dir), zap.String("workspace", workspace))
+func (I *Zap) StaleLock(_ context.Context, loc Location, lock Lock) error {
+	I.Logger.Warn("Workspace has a stale lock", append(zapLocation(loc), zap.Int("pull", lock.PullID), zap.String("pull-url", lock.PullURL), zap.String("user", lock.User), zap.Time("locked-since", lock.Since))...)
 	return nil
 }
 
+func (I *Zap) PersistentFailure(_ context.Context, loc Location, failures int, err error) error {
+	I.Logger.Error("Workspace keeps failing", append(zapLocation(loc), zap.Int("failures", failures), zap.Error(err))...)
+	return nil
+}
+
+func (I *Zap) ExtraWorkspaceInRemote(_ context.Context, loc Location) error {
+	I.Logger.Info("Extra workspace in remote", zapLocation(loc)...)
+	return nil
+}
+
+func (I *Zap) MissingWorkspaceInRemote(_ context.Context, loc Location) error {
+	I.Logger.Info("Missing workspace in remote", zapLocation(loc)...)
+	return nil
+}
+
+func zapLocation(loc Location) []zap.Field {
+	fields := []zap.Field{zap.String("dir", loc.Directory), zap.String("workspace", loc.Workspace)}
+	if loc.ProjectName != "" {
+		fields = append(fields, zap.String("project", loc.ProjectName))
+	}
+	return fields
+}
+
 var _ Notification = &Zap{}
@@ -20,10 +20,18 @@ func (d *ConsiderDriftChecked) String() string {
 type DriftCheckValue struct {
 	// If non-empty, indicates an error in the checking
 	Error string
-	// Only if we have an empty error: the result of checking for drift
+	// Only if Error is non-empty: how many checks in a row have failed
+	FailureCount int
+	// The result of the last successful check for drift
 	Drift bool `json:"drift"`
-	// Only if we have an empty error: when we did this check
+	// When we did this check
 	When time.Time
+	// Only if Drift: when we last sent a drift notification.  Survives between checks so reminders can be spaced out.
+	LastNotified time.Time
+	// Only if the plan was locked: when the lock was taken, or when we first saw it
+	LockedSince time.Time
+	// Only if the plan was locked: the pull request holding the lock
+	LockPullID int
 }
 
 type ConsiderWorkspacesChecked struct {
@@ -38,12 +46,24 @@ func (d *ConsiderWorkspacesChecked) String() string {
 type WorkspacesCheckedValue struct {
 	// If non-empty, indicates an error in the checking
 	Error string
+	// Only if Error is non-empty: how many checks in a row have failed
+	FailureCount int
 	// Worksaces we remember in this remote
 	Workspaces []string
-	// Only if we have an empty error: when we did this check
+	// When we did this check
 	When time.Time
 }
 
+// AcknowledgmentValue silences drift notifications for the directory/workspace of its key until it expires
+type AcknowledgmentValue struct {
+	// Why the drift is expected
+	Reason string
+	// Who acknowledged the drift
+	By string
+	// When drift notifications start again
+	Expires time.Time
+}
+
 type ProcessedCache interface {
 	GetDriftCheckResult(ctx context.Context, key *ConsiderDriftChecked) (*DriftCheckValue, error)
 	DeleteDriftCheckResult(ctx context.Context, key *ConsiderDriftChecked) error
@@ -51,6 +71,9 @@ type ProcessedCache interface {
 	GetRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspacesChecked) (*WorkspacesCheckedValue, error)
 	StoreRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspacesChecked, value *WorkspacesCheckedValue) error
 	DeleteRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspacesChecked) error
+	GetAcknowledgment(ctx context.Context, key *ConsiderDriftChecked) (*AcknowledgmentValue, error)
+	StoreAcknowledgment(ctx context.Context, key *ConsiderDriftChecked, value *AcknowledgmentValue) error
+	DeleteAcknowledgment(ctx context.Context, key *ConsiderDriftChecked) error
 }
 
 type Noop struct{}
@@ -79,4 +102,16 @@ func (n Noop) DeleteRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspace
 	return nil
 }
 
+func (n Noop) GetAcknowledgment(ctx context.Context, key *ConsiderDriftChecked) (*AcknowledgmentValue, error) {
+	return nil, nil
+}
+
+func (n Noop) StoreAcknowledgment(ctx context.Context, key *ConsiderDriftChecked, value *AcknowledgmentValue) error {
+	return nil
+}
+
+func (n Noop) DeleteAcknowledgment(ctx context.Context, key *ConsiderDriftChecked) error {
+	return nil
+}
+
 var _ ProcessedCache = &Noop{}
@@ -14,9 +14,11 @@ func GenericCacheWorkflowTest(t *testing.T, cache ProcessedCache) {
 		Workspace: "test",
 	}
 	testValue := &DriftCheckValue{
-		Error: "test",
-		Drift: true,
-		When:  currentTime,
+		Error:        "test",
+		FailureCount: 2,
+		Drift:        true,
+		When:         currentTime,
+		LastNotified: currentTime.Add(-time.Hour),
 	}
 	ctx := context.Background()
 	item, err := cache.GetDriftCheckResult(ctx, testKey)
@@ -33,4 +35,21 @@ func GenericCacheWorkflowTest(t *testing.T, cache ProcessedCache) {
 	item, err = cache.GetDriftCheckResult(ctx, testKey)
 	require.NoError(t, err)
 	require.Nil(t, item)
+
+	ack := &AcknowledgmentValue{
+		Reason:  "test",
+		By:      "tester",
+		Expires: currentTime.Add(time.Hour),
+	}
+	ackItem, err := cache.GetAcknowledgment(ctx, testKey)
+	require.NoError(t, err)
+	require.Nil(t, ackItem)
+	require.NoError(t, cache.StoreAcknowledgment(ctx, testKey, ack))
+	ackItem, err = cache.GetAcknowledgment(ctx, testKey)
+	require.NoError(t, err)
+	require.Equal(t, ack, ackItem)
+	require.NoError(t, cache.DeleteAcknowledgment(ctx, testKey))
+	ackItem, err = cache.GetAcknowledgment(ctx, testKey)
+	require.NoError(t, err)
+	require.Nil(t, ackItem)
 }
@@ -140,4 +140,22 @@ func (d *DynamoDB) DeleteRemoteWorkspaces(ctx context.Context, key *ConsiderWork
 	return d.genericDelete(ctx, "ConsiderWorkspacesChecked", key)
 }
 
+func (d *DynamoDB) GetAcknowledgment(ctx context.Context, key *ConsiderDriftChecked) (*AcknowledgmentValue, error) {
+	var ret AcknowledgmentValue
+	if exists, err := d.genericGet(ctx, "Acknowledgment", key, &ret); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, nil
+	}
+	return &ret, nil
+}
+
+func (d *DynamoDB) StoreAcknowledgment(ctx context.Context, key *ConsiderDriftChecked, value *AcknowledgmentValue) error {
+	return d.genericStore(ctx, "Acknowledgment", key, value)
+}
+
+func (d *DynamoDB) DeleteAcknowledgment(ctx context.Context, key *ConsiderDriftChecked) error {
+	return d.genericDelete(ctx, "Acknowledgment", key)
+}
+
 var _ ProcessedCache = &DynamoDB{}
@@ -0,0 +1,95 @@
+package processedcache
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory is a cache that only lives as long as the process.  Useful for long-running processes that do not have a
+// persistent cache configured.
+type Memory struct {
+	mu               sync.Mutex
+	driftChecks      map[string]DriftCheckValue
+	remoteWorkspaces map[string]WorkspacesCheckedValue
+	acknowledgments  map[string]AcknowledgmentValue
+}
+
+func (m *Memory) GetDriftCheckResult(_ context.Context, key *ConsiderDriftChecked) (*DriftCheckValue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v, exists := m.driftChecks[key.String()]; exists {
+		return &v, nil
+	}
+	return nil, nil
+}
+
+func (m *Memory) DeleteDriftCheckResult(_ context.Context, key *ConsiderDriftChecked) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.driftChecks, key.String())
+	return nil
+}
+
+func (m *Memory) StoreDriftCheckResult(_ context.Context, key *ConsiderDriftChecked, value *DriftCheckValue) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.driftChecks == nil {
+		m.driftChecks = make(map[string]DriftCheckValue)
+	}
+	m.driftChecks[key.String()] = *value
+	return nil
+}
+
+func (m *Memory) GetRemoteWorkspaces(_ context.Context, key *ConsiderWorkspacesChecked) (*WorkspacesCheckedValue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v, exists := m.remoteWorkspaces[key.String()]; exists {
+		return &v, nil
+	}
+	return nil, nil
+}
+
+func (m *Memory) StoreRemoteWorkspaces(_ context.Context, key *ConsiderWorkspacesChecked, value *WorkspacesCheckedValue) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.remoteWorkspaces == nil {
+		m.remoteWorkspaces = make(map[string]WorkspacesCheckedValue)
+	}
+	m.remoteWorkspaces[key.String()] = *value
+	return nil
+}
+
+func (m *Memory) DeleteRemoteWorkspaces(_ context.Context, key *ConsiderWorkspacesChecked) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.remoteWorkspaces, key.String())
+	return nil
+}
+
+func (m *Memory) GetAcknowledgment(_ context.Context, key *ConsiderDriftChecked) (*AcknowledgmentValue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v, exists := m.acknowledgments[key.String()]; exists {
+		return &v, nil
+	}
+	return nil, nil
+}
+
+func (m *Memory) StoreAcknowledgment(_ context.Context, key *ConsiderDriftChecked, value *AcknowledgmentValue) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.acknowledgments == nil {
+		m.acknowledgments = make(map[string]AcknowledgmentValue)
+	}
+	m.acknowledgments[key.String()] = *value
+	return nil
+}
+
+func (m *Memory) DeleteAcknowledgment(_ context.Context, key *ConsiderDriftChecked) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.acknowledgments, key.String())
+	return nil
+}
+
+var _ ProcessedCache = &Memory{}
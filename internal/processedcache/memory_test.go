@@ -0,0 +1,13 @@
+package processedcache
+
+import (
+	"testing"
+)
+
+func TestMemory(t *testing.T) {
+	GenericCacheWorkflowTest(t, &Memory{})
+}
+
+func TestTraced(t *testing.T) {
+	GenericCacheWorkflowTest(t, &Traced{Cache: &Memory{}})
+}
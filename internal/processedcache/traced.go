@@ -0,0 +1,101 @@
+package processedcache
+
+import (
+	"context"
+
+	"github.com/cresta/atlantis-drift-detection/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/cresta/atlantis-drift-detection/internal/processedcache"
+
+// Traced wraps a cache, recording a span for every operation
+type Traced struct {
+	Cache ProcessedCache
+}
+
+func driftCheckAttributes(key *ConsiderDriftChecked) trace.SpanStartOption {
+	return trace.WithAttributes(tracing.DirKey.String(key.Dir), tracing.WorkspaceKey.String(key.Workspace))
+}
+
+func workspacesCheckedAttributes(key *ConsiderWorkspacesChecked) trace.SpanStartOption {
+	return trace.WithAttributes(tracing.DirKey.String(key.Dir))
+}
+
+func (t *Traced) GetDriftCheckResult(ctx context.Context, key *ConsiderDriftChecked) (*DriftCheckValue, error) {
+	ctx, span := tracing.Tracer(tracerName).Start(ctx, "GetDriftCheckResult", driftCheckAttributes(key))
+	defer span.End()
+	ret, err := t.Cache.GetDriftCheckResult(ctx, key)
+	span.SetAttributes(attribute.Bool("cache.hit", ret != nil))
+	tracing.RecordError(span, err)
+	return ret, err
+}
+
+func (t *Traced) DeleteDriftCheckResult(ctx context.Context, key *ConsiderDriftChecked) error {
+	ctx, span := tracing.Tracer(tracerName).Start(ctx, "DeleteDriftCheckResult", driftCheckAttributes(key))
+	defer span.End()
+	err := t.Cache.DeleteDriftCheckResult(ctx, key)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *Traced) StoreDriftCheckResult(ctx context.Context, key *ConsiderDriftChecked, value *DriftCheckValue) error {
+	ctx, span := tracing.Tracer(tracerName).Start(ctx, "StoreDriftCheckResult", driftCheckAttributes(key))
+	defer span.End()
+	err := t.Cache.StoreDriftCheckResult(ctx, key, value)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *Traced) GetRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspacesChecked) (*WorkspacesCheckedValue, error) {
+	ctx, span := tracing.Tracer(tracerName).Start(ctx, "GetRemoteWorkspaces", workspacesCheckedAttributes(key))
+	defer span.End()
+	ret, err := t.Cache.GetRemoteWorkspaces(ctx, key)
+	span.SetAttributes(attribute.Bool("cache.hit", ret != nil))
+	tracing.RecordError(span, err)
+	return ret, err
+}
+
+func (t *Traced) StoreRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspacesChecked, value *WorkspacesCheckedValue) error {
+	ctx, span := tracing.Tracer(tracerName).Start(ctx, "StoreRemoteWorkspaces", workspacesCheckedAttributes(key))
+	defer span.End()
+	err := t.Cache.StoreRemoteWorkspaces(ctx, key, value)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *Traced) DeleteRemoteWorkspaces(ctx context.Context, key *ConsiderWorkspacesChecked) error {
+	ctx, span := tracing.Tracer(tracerName).Start(ctx, "DeleteRemoteWorkspaces", workspacesCheckedAttributes(key))
+	defer span.End()
+	err := t.Cache.DeleteRemoteWorkspaces(ctx, key)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *Traced) GetAcknowledgment(ctx context.Context, key *ConsiderDriftChecked) (*AcknowledgmentValue, error) {
+	ctx, span := tracing.Tracer(tracerName).Start(ctx, "GetAcknowledgment", driftCheckAttributes(key))
+	defer span.End()
+	ret, err := t.Cache.GetAcknowledgment(ctx, key)
+	span.SetAttributes(attribute.Bool("cache.hit", ret != nil))
+	tracing.RecordError(span, err)
+	return ret, err
+}
+
+func (t *Traced) StoreAcknowledgment(ctx context.Context, key *ConsiderDriftChecked, value *AcknowledgmentValue) error {
+	ctx, span := tracing.Tracer(tracerName).Start(ctx, "StoreAcknowledgment", driftCheckAttributes(key))
+	defer span.End()
+	err := t.Cache.StoreAcknowledgment(ctx, key, value)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *Traced) DeleteAcknowledgment(ctx context.Context, key *ConsiderDriftChecked) error {
+	ctx, span := tracing.Tracer(tracerName).Start(ctx, "DeleteAcknowledgment", driftCheckAttributes(key))
+	defer span.End()
+	err := t.Cache.DeleteAcknowledgment(ctx, key)
+	tracing.RecordError(span, err)
+	return err
+}
+
+var _ ProcessedCache = &Traced{}
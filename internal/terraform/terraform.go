@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"github.com/cresta/atlantis-drift-detection/internal/tracing"
 	"github.com/cresta/pipe"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"os"
 	"path/filepath"
 	"strings"
 )
@@ -29,7 +32,17 @@ func (e *execErr) Error() string {
 	return fmt.Sprintf("%s:%s:%s", e.stdout.String(), e.stderr.String(), e.root.Error())
 }
 
+const tracerName = "github.com/cresta/atlantis-drift-detection/internal/terraform"
+
 func (c *Client) Init(ctx context.Context, subDir string) error {
+	ctx, span := tracing.Tracer(tracerName).Start(ctx, "Init", trace.WithAttributes(tracing.DirKey.String(subDir)))
+	defer span.End()
+	err := c.init(ctx, subDir)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (c *Client) init(ctx context.Context, subDir string) error {
 	c.Logger.Info("Initializing terraform", zap.String("dir", subDir))
 	var stdout, stderr bytes.Buffer
 	result := pipe.NewPiped("terraform", "init", "-no-color").WithDir(filepath.Join(c.Directory, subDir)).Execute(ctx, nil, &stdout, &stderr)
@@ -44,6 +57,14 @@ func (c *Client) Init(ctx context.Context, subDir string) error {
 }
 
 func (c *Client) ListWorkspaces(ctx context.Context, subDir string) ([]string, error) {
+	ctx, span := tracing.Tracer(tracerName).Start(ctx, "ListWorkspaces", trace.WithAttributes(tracing.DirKey.String(subDir)))
+	defer span.End()
+	ret, err := c.listWorkspaces(ctx, subDir)
+	tracing.RecordError(span, err)
+	return ret, err
+}
+
+func (c *Client) listWorkspaces(ctx context.Context, subDir string) ([]string, error) {
 	c.Logger.Info("Listing workspaces", zap.String("dir", subDir))
 	var stdout, stderr bytes.Buffer
 	result := pipe.NewPiped("terraform", "workspace", "list").WithDir(filepath.Join(c.Directory, subDir)).Execute(ctx, nil, &stdout, &stderr)
@@ -66,3 +87,37 @@ func (c *Client) ListWorkspaces(ctx context.Context, subDir string) ([]string, e
 	}
 	return workspaces, nil
 }
+
+// CountStateResources returns how many resources the remote state of workspace holds
+func (c *Client) CountStateResources(ctx context.Context, subDir string, workspace string) (int, error) {
+	ctx, span := tracing.Tracer(tracerName).Start(ctx, "CountStateResources", trace.WithAttributes(tracing.DirKey.String(subDir), tracing.WorkspaceKey.String(workspace)))
+	defer span.End()
+	ret, err := c.countStateResources(ctx, subDir, workspace)
+	tracing.RecordError(span, err)
+	return ret, err
+}
+
+func (c *Client) countStateResources(ctx context.Context, subDir string, workspace string) (int, error) {
+	c.Logger.Info("Listing state", zap.String("dir", subDir), zap.String("workspace", workspace))
+	var stdout, stderr bytes.Buffer
+	env := append(os.Environ(), "TF_WORKSPACE="+workspace)
+	result := pipe.NewPiped("terraform", "state", "list").WithEnv(env).WithDir(filepath.Join(c.Directory, subDir)).Execute(ctx, nil, &stdout, &stderr)
+	if result != nil {
+		// A workspace that was never written to has no state at all, which terraform treats as an error
+		if strings.Contains(stderr.String(), "No state file was found") {
+			return 0, nil
+		}
+		return 0, &execErr{
+			stdout: stdout,
+			stderr: stderr,
+			root:   result,
+		}
+	}
+	count := 0
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if strings.TrimSpace(line) != "" {
+			count++
+		}
+	}
+	return count, nil
+}
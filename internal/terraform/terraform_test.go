@@ -46,3 +46,15 @@ func TestClient_ListWorkspaces(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, []string{"default", "testing"}, workspaces)
 }
+
+func TestClient_CountStateResources(t *testing.T) {
+	td := t.TempDir()
+	c := Client{
+		Directory: td,
+		Logger:    zaptest.NewLogger(t),
+	}
+	require.NoError(t, c.Init(context.Background(), ""))
+	count, err := c.CountStateResources(context.Background(), "", "default")
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+}
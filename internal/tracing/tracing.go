@@ -0,0 +1,64 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const ServiceName = "atlantis-drift-detection"
+
+// Span attributes shared by every package that traces work on a directory/workspace
+const (
+	DirKey       = attribute.Key("drift.dir")
+	WorkspaceKey = attribute.Key("drift.workspace")
+)
+
+// Tracer returns the tracer called name.  It is looked up on every use, rather than once per package, so that spans
+// go to whichever provider is installed at the time, including one a test installs after the package was loaded.
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// Enabled returns true if the standard OTLP environment variables point somewhere to send traces
+func Enabled() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Setup installs a global tracer provider that exports spans over OTLP/HTTP.  The exporter is configured with the
+// standard OTEL_EXPORTER_OTLP_* environment variables, plus any options passed in.  The returned function flushes
+// and stops the exporter.
+func Setup(ctx context.Context, opts ...otlptracehttp.Option) (func(ctx context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create otlp exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// RecordError marks span as failed if err is not nil
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector is an in-process OTLP/HTTP trace receiver
+type collector struct {
+	mu    sync.Mutex
+	spans []*tracepb.Span
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req coltracepb.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+	c.mu.Unlock()
+	resp, err := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(resp)
+}
+
+func TestSetup(t *testing.T) {
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	ctx := context.Background()
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+	})
+	shutdown, err := Setup(ctx, otlptracehttp.WithEndpointURL(srv.URL+"/v1/traces"))
+	require.NoError(t, err)
+	_, span := otel.Tracer("test").Start(ctx, "test-span")
+	span.SetAttributes(DirKey.String("environments/aws/example"), WorkspaceKey.String("prod"))
+	span.End()
+	require.NoError(t, shutdown(ctx))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	require.Len(t, c.spans, 1)
+	require.Equal(t, "test-span", c.spans[0].Name)
+	attrs := map[string]string{}
+	for _, a := range c.spans[0].Attributes {
+		attrs[a.Key] = a.Value.GetStringValue()
+	}
+	require.Equal(t, "environments/aws/example", attrs[string(DirKey)])
+	require.Equal(t, "prod", attrs[string(WorkspaceKey)])
+}